@@ -0,0 +1,108 @@
+package gannoy
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Extensions of files of a database. Meta file is the last,
+// so that it is written after the files it refers.
+var databaseExts = []string{"tree", "leaves", "vec", "pq", "bolt", "changes", "wal", "meta"}
+
+// Backup copies files of the database into dir. Writes are paused by the builder
+// while copying, so that the copy is consistent.
+func (g *GannoyIndex) Backup(dir string) error {
+	if g.meta.path == "" {
+		return fmt.Errorf("Database in memory can not be backed up. Use Snapshot.")
+	}
+	if g.readOnly {
+		return g.backup(dir)
+	}
+	args := buildArgs{action: BACKUP, dir: dir, result: make(chan error)}
+	g.buildChan <- args
+	return <-args.result
+}
+
+func (g *GannoyIndex) backup(dir string) error {
+	path := filepath.Dir(g.meta.path)
+	if sameDir(path, dir) {
+		return fmt.Errorf("Backup directory must differ from data directory.")
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	return copyDatabase(path, dir, databaseNameOf(g.meta.path))
+}
+
+// databaseNameOf returns database name of the meta file.
+func databaseNameOf(metaFile string) string {
+	return strings.TrimSuffix(filepath.Base(metaFile), ".meta")
+}
+
+// Restore copies files of the database name from backup directory dir into path.
+// Stop gannoy-db before restore.
+func Restore(dir, path, name string) error {
+	if sameDir(path, dir) {
+		return fmt.Errorf("Backup directory must differ from data directory.")
+	}
+	if _, err := os.Stat(filepath.Join(dir, name+".meta")); err != nil {
+		return fmt.Errorf("Backup of %s is not found in %s.", name, dir)
+	}
+	return copyDatabase(dir, path, name)
+}
+
+// copyDatabase copies files of the database name from src to dst.
+// Files which are not in src are removed from dst.
+func copyDatabase(src, dst, name string) error {
+	for _, ext := range databaseExts {
+		from := filepath.Join(src, name+"."+ext)
+		to := filepath.Join(dst, name+"."+ext)
+		if _, err := os.Stat(from); err != nil {
+			os.Remove(to)
+			continue
+		}
+		if err := copyFile(from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile copies src into temporary file, and renames it to dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+func sameDir(a, b string) bool {
+	a, errA := filepath.Abs(a)
+	b, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && a == b
+}
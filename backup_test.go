@@ -0,0 +1,73 @@
+package gannoy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGannoyIndexBackupAndRestore(t *testing.T) {
+	name := "test_gannoy_index_backup"
+	CreateMeta(".", name, 2, 3, 4)
+	defer os.Remove(name + ".meta")
+	defer os.Remove(name + ".tree")
+	defer os.Remove(name + ".leaves")
+
+	backup := "test_gannoy_index_backup_dir"
+	restore := "test_gannoy_index_backup_restore"
+	defer os.RemoveAll(backup)
+	defer os.RemoveAll(restore)
+
+	gannoy, _ := NewGannoyIndex(name+".meta", Angular{}, &TestLoopRandom{max: 1})
+	items := [][]float64{
+		{1.1, 1.2, 1.3},
+		{-1.1, -1.2, -1.3},
+		{1.1, 1.2, 1.3},
+		{-1.1, -1.2, -1.3},
+	}
+	for i, item := range items {
+		gannoy.AddItem(i*10, item)
+	}
+
+	if err := gannoy.Backup("."); err == nil {
+		t.Errorf("GannoyIndex Backup into data directory should return error.")
+	}
+
+	// Writes during backup wait for it.
+	done := make(chan error)
+	go func() {
+		done <- gannoy.AddItem(40, []float64{-1.1, -1.2, -1.3})
+	}()
+	if err := gannoy.Backup(backup); err != nil {
+		t.Errorf("GannoyIndex Backup should not return error, but %v", err)
+	}
+	<-done
+
+	os.Mkdir(restore, os.ModePerm)
+	if err := Restore(backup, restore, "unknown"); err == nil {
+		t.Errorf("Restore of unknown database should return error.")
+	}
+	if err := Restore(backup, restore, name); err != nil {
+		t.Errorf("Restore should not return error, but %v", err)
+	}
+
+	restored, err := NewGannoyIndex(filepath.Join(restore, name+".meta"), Angular{}, &TestLoopRandom{max: 1})
+	if err != nil {
+		t.Errorf("Restored database should be loaded, but %v", err)
+	}
+	// Item added during backup is included or not as a whole.
+	if keys := restored.nodes.maps.keys(); len(keys) != len(items) && len(keys) != len(items)+1 {
+		t.Errorf("Restored database should have %d or %d items, but %v", len(items), len(items)+1, keys)
+	}
+	nns, _ := restored.GetNnsByKey(0, 2, -1)
+	if len(nns) != 2 || nns[0] != 0 || nns[1] != 20 {
+		t.Errorf("Restored database should return %v, but %v", []int{0, 20}, nns)
+	}
+}
+
+func TestMemoryGannoyIndexBackup(t *testing.T) {
+	gannoy := NewMemoryGannoyIndex(2, 3, 4, Angular{}, RandRandom{})
+	if err := gannoy.Backup("test_memory_gannoy_index_backup"); err == nil {
+		t.Errorf("GannoyIndex Backup of database in memory should return error.")
+	}
+}
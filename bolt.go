@@ -0,0 +1,290 @@
+package gannoy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltNodes = []byte("nodes")
+	boltRoots = []byte("roots")
+)
+
+// BoltStorage keeps nodes and roots in a bbolt file.
+// All writes of a mutation between begin and commit are applied in a single
+// transaction, so a crash never leaves a half-applied tree.
+type BoltStorage struct {
+	db   *bolt.DB
+	tree int
+
+	mu sync.Mutex // guards tx
+	tx *bolt.Tx   // write transaction of the current mutation
+}
+
+func newBoltStorage(m meta, readOnly bool) (*BoltStorage, error) {
+	if m.encoding != FLOAT64 {
+		return nil, fmt.Errorf("Bolt storage supports only float64 encoding.")
+	}
+	db, err := bolt.Open(m.boltPath(), 0666, &bolt.Options{ReadOnly: readOnly})
+	if err != nil {
+		return nil, err
+	}
+	s := &BoltStorage{db: db, tree: m.tree}
+	if readOnly {
+		return s, nil
+	}
+
+	// Roots are moved from meta file when bolt file is created.
+	roots := m.roots()
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltNodes); err != nil {
+			return err
+		}
+		b := tx.Bucket(boltRoots)
+		if b != nil {
+			return nil
+		}
+		b, err := tx.CreateBucket(boltRoots)
+		if err != nil {
+			return err
+		}
+		for index, root := range roots {
+			if err := b.Put(boltId(index), boltId(root)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *BoltStorage) begin() error {
+	tx, err := s.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.tx = tx
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *BoltStorage) commit() error {
+	s.mu.Lock()
+	tx := s.tx
+	s.tx = nil
+	s.mu.Unlock()
+	return tx.Commit()
+}
+
+func (s *BoltStorage) rollback() error {
+	s.mu.Lock()
+	tx := s.tx
+	s.tx = nil
+	s.mu.Unlock()
+	return tx.Rollback()
+}
+
+// view runs f in the current write transaction, or in a new read transaction.
+// Readers see uncommitted nodes of the current mutation like File does.
+func (s *BoltStorage) view(f func(*bolt.Tx) error) error {
+	s.mu.Lock()
+	if s.tx != nil {
+		defer s.mu.Unlock()
+		return f(s.tx)
+	}
+	s.mu.Unlock()
+	return s.db.View(f)
+}
+
+// update runs f in the current write transaction, or in its own transaction
+// if it is called outside of a mutation.
+func (s *BoltStorage) update(f func(*bolt.Tx) error) error {
+	s.mu.Lock()
+	if s.tx != nil {
+		defer s.mu.Unlock()
+		return f(s.tx)
+	}
+	s.mu.Unlock()
+	return s.db.Update(f)
+}
+
+func (s *BoltStorage) Create(n Node) (int, error) {
+	var id int
+	err := s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltNodes)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		id = int(seq) - 1
+		return b.Put(boltId(id), s.nodeToBytes(n))
+	})
+	return id, err
+}
+
+func (s *BoltStorage) Find(id int) (Node, error) {
+	var node Node
+	err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltNodes).Get(boltId(id))
+		if b == nil {
+			return fmt.Errorf("Node %d is not found.", id)
+		}
+		node = s.bytesToNode(id, b)
+		return nil
+	})
+	node.id = id
+	node.storage = s
+	return node, err
+}
+
+func (s *BoltStorage) Update(n Node) error {
+	return s.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltNodes).Put(boltId(n.id), s.nodeToBytes(n))
+	})
+}
+
+func (s *BoltStorage) UpdateParent(id, rootIndex, parent int) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltNodes)
+		v := b.Get(boltId(id))
+		if v == nil {
+			return fmt.Errorf("Node %d is not found.", id)
+		}
+		node := s.bytesToNode(id, v)
+		node.parents[rootIndex] = parent
+		return b.Put(boltId(id), s.nodeToBytes(node))
+	})
+}
+
+func (s *BoltStorage) Delete(n Node) error {
+	n.free = true
+	return s.Update(n)
+}
+
+func (s *BoltStorage) Iterate(c chan Node) {
+	// Ids are collected first, so that a slow receiver does not hold a transaction.
+	ids := []int{}
+	s.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltNodes).ForEach(func(k, _ []byte) error {
+			ids = append(ids, int(int32(binary.BigEndian.Uint32(k))))
+			return nil
+		})
+	})
+	for _, id := range ids {
+		n, err := s.Find(id)
+		if err != nil {
+			break
+		}
+		c <- n
+	}
+	close(c)
+}
+
+func (s *BoltStorage) roots() []int {
+	roots := make([]int, s.tree)
+	s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltRoots)
+		for index := range roots {
+			roots[index] = int(int32(binary.BigEndian.Uint32(b.Get(boltId(index)))))
+		}
+		return nil
+	})
+	return roots
+}
+
+func (s *BoltStorage) updateRoot(index, root int) error {
+	return s.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltRoots).Put(boltId(index), boltId(root))
+	})
+}
+
+func (s *BoltStorage) nodeCount() int {
+	var count int
+	s.view(func(tx *bolt.Tx) error {
+		count = tx.Bucket(boltNodes).Stats().KeyN
+		return nil
+	})
+	return count
+}
+
+func (s *BoltStorage) size() int64 {
+	var size int64
+	s.db.View(func(tx *bolt.Tx) error {
+		size = tx.Size()
+		return nil
+	})
+	return size
+}
+
+func (s *BoltStorage) Close() error {
+	return s.db.Close()
+}
+
+func boltId(id int) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(id))
+	return b
+}
+
+// nodeToBytes encodes node as
+// free(1) nDescendants(4) key(4) parents(4*tree) nChildren(4) children(4*n) v(8*dim).
+func (s *BoltStorage) nodeToBytes(node Node) []byte {
+	b := make([]byte, 1+4+4+4*s.tree+4+4*len(node.children)+8*len(node.v))
+	if node.free {
+		b[0] = 1
+	}
+	binary.BigEndian.PutUint32(b[1:5], uint32(node.nDescendants))
+	binary.BigEndian.PutUint32(b[5:9], uint32(node.key))
+	offset := 9
+	for i := 0; i < s.tree; i++ {
+		binary.BigEndian.PutUint32(b[offset:], uint32(node.parents[i]))
+		offset += 4
+	}
+	binary.BigEndian.PutUint32(b[offset:], uint32(len(node.children)))
+	offset += 4
+	for _, child := range node.children {
+		binary.BigEndian.PutUint32(b[offset:], uint32(child))
+		offset += 4
+	}
+	for _, x := range node.v {
+		binary.BigEndian.PutUint64(b[offset:], math.Float64bits(x))
+		offset += 8
+	}
+	return b
+}
+
+func (s *BoltStorage) bytesToNode(id int, b []byte) Node {
+	node := Node{
+		id:           id,
+		free:         b[0] == 1,
+		nDescendants: int(int32(binary.BigEndian.Uint32(b[1:5]))),
+		key:          int(int32(binary.BigEndian.Uint32(b[5:9]))),
+		parents:      make([]int, s.tree),
+	}
+	offset := 9
+	for i := 0; i < s.tree; i++ {
+		node.parents[i] = int(int32(binary.BigEndian.Uint32(b[offset:])))
+		offset += 4
+	}
+	node.children = make([]int, int(binary.BigEndian.Uint32(b[offset:])))
+	offset += 4
+	for i := range node.children {
+		node.children[i] = int(int32(binary.BigEndian.Uint32(b[offset:])))
+		offset += 4
+	}
+	node.v = make([]float64, (len(b)-offset)/8)
+	for i := range node.v {
+		node.v[i] = math.Float64frombits(binary.BigEndian.Uint64(b[offset:]))
+		offset += 8
+	}
+	return node
+}
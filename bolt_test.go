@@ -0,0 +1,107 @@
+package gannoy
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestBoltStorage(t *testing.T) {
+	name := "test_bolt_storage"
+	CreateMeta(".", name, 2, 3, 4)
+	defer os.Remove(name + ".meta")
+	defer os.Remove(name + ".bolt")
+
+	gannoy, err := NewGannoyIndexWithStorage(name+".meta", Angular{}, &TestLoopRandom{max: 1}, BOLT)
+	if err != nil {
+		t.Fatalf("NewGannoyIndexWithStorage with bolt should not return error, but %v", err)
+	}
+	items := [][]float64{
+		{1.1, 1.2, 1.3},
+		{-1.1, -1.2, -1.3},
+		{1.1, 1.2, 1.3},
+		{-1.1, -1.2, -1.3},
+		{-1.1, -1.2, -1.3},
+	}
+	for i, item := range items {
+		if err := gannoy.AddItem(i*10, item); err != nil {
+			t.Errorf("GannoyIndex AddItem with bolt should not return error, but %v", err)
+		}
+	}
+	if _, err := os.Stat(name + ".bolt"); err != nil {
+		t.Errorf("Bolt storage should create bolt file, but %v", err)
+	}
+	nns, _ := gannoy.GetNnsByKey(40, 3, -1)
+	roots := gannoy.meta.roots()
+	gannoy.nodes.Storage.(*BoltStorage).Close()
+
+	// roots are kept in bolt file instead of meta file.
+	meta, _ := loadMeta(name + ".meta")
+	if meta.roots()[0] != -1 {
+		t.Errorf("Bolt storage should not write roots to meta file, but %v", meta.roots())
+	}
+
+	reopened, _ := NewGannoyIndexWithOptions(name+".meta", Options{Storage: StorageFactoryOf(BOLT), ReadOnly: true})
+	defer reopened.nodes.Storage.(*BoltStorage).Close()
+	for i, root := range reopened.meta.roots() {
+		if root != roots[i] {
+			t.Errorf("Bolt storage should keep roots %v, but %v", roots, reopened.meta.roots())
+			break
+		}
+	}
+	reopenedNns, _ := reopened.GetNnsByKey(40, 3, -1)
+	for i, key := range nns {
+		if reopenedNns[i] != key {
+			t.Errorf("Bolt storage should return same result %v, but %v", nns, reopenedNns)
+			break
+		}
+	}
+}
+
+func TestBoltStorageRollback(t *testing.T) {
+	name := "test_bolt_storage_rollback"
+	CreateMeta(".", name, 2, 3, 4)
+	defer os.Remove(name + ".meta")
+	defer os.Remove(name + ".bolt")
+
+	gannoy, _ := NewGannoyIndexWithStorage(name+".meta", Angular{}, RandRandom{}, BOLT)
+	defer gannoy.nodes.Storage.(*BoltStorage).Close()
+	gannoy.AddItem(10, []float64{1.1, 1.2, 1.3})
+	storage := gannoy.nodes.Storage.(*BoltStorage)
+	nodeCount := storage.nodeCount()
+	roots := gannoy.meta.roots()
+
+	err := gannoy.transaction(func() error {
+		if err := gannoy.addItem(20, []float64{-1.1, -1.2, -1.3}); err != nil {
+			return err
+		}
+		return fmt.Errorf("crash")
+	})
+	if err == nil {
+		t.Errorf("GannoyIndex transaction should return error of mutation.")
+	}
+	if storage.nodeCount() != nodeCount {
+		t.Errorf("Rolled back mutation should not write nodes, but %d nodes", storage.nodeCount())
+	}
+	if gannoy.meta.roots()[0] != roots[0] || gannoy.meta.roots()[1] != roots[1] {
+		t.Errorf("Rolled back mutation should not write roots, but %v", gannoy.meta.roots())
+	}
+	if gannoy.nodes.maps.isExist(20) {
+		t.Errorf("Rolled back mutation should not remain in maps.")
+	}
+
+	if err := gannoy.AddItem(20, []float64{-1.1, -1.2, -1.3}); err != nil {
+		t.Errorf("GannoyIndex AddItem after rollback should not return error, but %v", err)
+	}
+}
+
+func TestBoltStorageOnlyFloat64(t *testing.T) {
+	name := "test_bolt_storage_only_float64"
+	CreateMetaWithOptions(".", name, 2, 3, 4, MetaOptions{Encoding: FLOAT32})
+	defer os.Remove(name + ".meta")
+
+	_, err := NewGannoyIndexWithStorage(name+".meta", Angular{}, RandRandom{}, BOLT)
+	if err == nil {
+		t.Errorf("Bolt storage with float32 encoding should return error.")
+	}
+}
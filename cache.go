@@ -0,0 +1,173 @@
+package gannoy
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// CacheStorage caches decoded nodes of another Storage in LRU order.
+// Cached nodes are invalidated when they are updated through it.
+type CacheStorage struct {
+	Storage
+	capacity int
+
+	mu      sync.Mutex
+	entries map[int]*list.Element
+	lru     *list.List
+	gen     uint64 // incremented by each write, so that stale reads are not cached
+
+	hits   int64
+	misses int64
+}
+
+type cacheEntry struct {
+	id   int
+	node Node
+}
+
+// CacheStats reports usage of node cache.
+type CacheStats struct {
+	Capacity int     `json:"capacity"`
+	Size     int     `json:"size"`
+	Hits     int64   `json:"hits"`
+	Misses   int64   `json:"misses"`
+	HitRate  float64 `json:"hit_rate"`
+}
+
+func newCacheStorage(storage Storage, capacity int) *CacheStorage {
+	return &CacheStorage{
+		Storage:  storage,
+		capacity: capacity,
+		entries:  map[int]*list.Element{},
+		lru:      list.New(),
+	}
+}
+
+func (c *CacheStorage) Find(id int) (Node, error) {
+	c.mu.Lock()
+	if e, ok := c.entries[id]; ok {
+		c.lru.MoveToFront(e)
+		node := copyNode(e.Value.(*cacheEntry).node)
+		c.mu.Unlock()
+		atomic.AddInt64(&c.hits, 1)
+		node.storage = c
+		return node, nil
+	}
+	gen := c.gen
+	c.mu.Unlock()
+	atomic.AddInt64(&c.misses, 1)
+
+	node, err := c.Storage.Find(id)
+	if err != nil {
+		return node, err
+	}
+
+	c.mu.Lock()
+	if gen == c.gen {
+		c.add(id, node)
+	}
+	c.mu.Unlock()
+	node.storage = c
+	return node, nil
+}
+
+// add must be called with lock.
+func (c *CacheStorage) add(id int, node Node) {
+	if e, ok := c.entries[id]; ok {
+		c.lru.MoveToFront(e)
+		e.Value.(*cacheEntry).node = copyNode(node)
+		return
+	}
+	c.entries[id] = c.lru.PushFront(&cacheEntry{id: id, node: copyNode(node)})
+	if c.lru.Len() > c.capacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).id)
+	}
+}
+
+func (c *CacheStorage) invalidate(id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	if e, ok := c.entries[id]; ok {
+		c.lru.Remove(e)
+		delete(c.entries, id)
+	}
+}
+
+// purge drops all cached nodes, e.g. after a transaction is rolled back.
+func (c *CacheStorage) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.entries = map[int]*list.Element{}
+	c.lru.Init()
+}
+
+func (c *CacheStorage) Create(n Node) (int, error) {
+	id, err := c.Storage.Create(n)
+	// Ids of rolled back nodes can be created again.
+	c.invalidate(id)
+	return id, err
+}
+
+func (c *CacheStorage) Update(n Node) error {
+	err := c.Storage.Update(n)
+	c.invalidate(n.id)
+	return err
+}
+
+func (c *CacheStorage) UpdateParent(id, rootIndex, parent int) error {
+	err := c.Storage.UpdateParent(id, rootIndex, parent)
+	c.invalidate(id)
+	return err
+}
+
+func (c *CacheStorage) Delete(n Node) error {
+	err := c.Storage.Delete(n)
+	c.invalidate(n.id)
+	return err
+}
+
+func (c *CacheStorage) Stats() CacheStats {
+	c.mu.Lock()
+	size := c.lru.Len()
+	c.mu.Unlock()
+
+	stats := CacheStats{
+		Capacity: c.capacity,
+		Size:     size,
+		Hits:     atomic.LoadInt64(&c.hits),
+		Misses:   atomic.LoadInt64(&c.misses),
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
+
+func (c *CacheStorage) unwrap() Storage {
+	return c.Storage
+}
+
+// wrapper is implemented by storages which wrap another storage.
+type wrapper interface {
+	unwrap() Storage
+}
+
+// backend returns the innermost storage, which optional interfaces
+// such as vectorFinder are implemented by.
+func (ns *Nodes) backend() Storage {
+	storage := ns.Storage
+	for {
+		w, ok := storage.(wrapper)
+		if !ok {
+			return storage
+		}
+		storage = w.unwrap()
+	}
+}
@@ -0,0 +1,117 @@
+package gannoy
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestCacheStorage(t *testing.T) {
+	cache := newCacheStorage(newMemoryStorage(), 2)
+	for i := 0; i < 3; i++ {
+		cache.Create(Node{key: i, nDescendants: 1, parents: []int{-1}, children: []int{0, 0}, v: []float64{float64(i)}})
+	}
+
+	cache.Find(0)
+	node, _ := cache.Find(0)
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("CacheStorage should count hits and misses, but %v", stats)
+	}
+	if node.storage != cache {
+		t.Errorf("CacheStorage Find should bind node to cache.")
+	}
+
+	// Modifying found node does not change cached node.
+	node.v[0] = 10.0
+	if cached, _ := cache.Find(0); cached.v[0] != 0.0 {
+		t.Errorf("CacheStorage should keep copy of node, but %v", cached.v)
+	}
+
+	cache.Update(node)
+	if updated, _ := cache.Find(0); updated.v[0] != 10.0 {
+		t.Errorf("CacheStorage Update should invalidate cached node, but %v", updated.v)
+	}
+	cache.UpdateParent(0, 0, 2)
+	if updated, _ := cache.Find(0); updated.parents[0] != 2 {
+		t.Errorf("CacheStorage UpdateParent should invalidate cached node, but %v", updated.parents)
+	}
+	cache.Delete(node)
+	if deleted, _ := cache.Find(0); !deleted.free {
+		t.Errorf("CacheStorage Delete should invalidate cached node.")
+	}
+
+	// evict least recently used node
+	cache.Find(1)
+	cache.Find(2)
+	if stats := cache.Stats(); stats.Size != 2 {
+		t.Errorf("CacheStorage should keep nodes up to capacity, but %d", stats.Size)
+	}
+	if _, ok := cache.entries[0]; ok {
+		t.Errorf("CacheStorage should evict least recently used node.")
+	}
+}
+
+func TestGannoyIndexWithCache(t *testing.T) {
+	name := "test_gannoy_index_with_cache"
+	CreateMeta(".", name, 2, 3, 4)
+	defer os.Remove(name + ".meta")
+	defer os.Remove(name + ".tree")
+	defer os.Remove(name + ".leaves")
+
+	gannoy, err := NewGannoyIndexWithOptions(name+".meta", Options{Random: &TestLoopRandom{max: 1}, CacheSize: 100})
+	if err != nil {
+		t.Errorf("NewGannoyIndexWithOptions with cache should not return error, but %v", err)
+	}
+	items := [][]float64{
+		{1.1, 1.2, 1.3},
+		{-1.1, -1.2, -1.3},
+		{1.1, 1.2, 1.3},
+		{-1.1, -1.2, -1.3},
+		{-1.1, -1.2, -1.3},
+	}
+	for i, item := range items {
+		gannoy.AddItem(i*10, item)
+	}
+
+	uncached, _ := NewGannoyIndex(name+".meta", Angular{}, RandRandom{})
+	expect, _ := uncached.GetNnsByKey(40, 3, -1)
+	for i := 0; i < 2; i++ {
+		nns, _ := gannoy.GetNnsByKey(40, 3, -1)
+		for j, key := range expect {
+			if nns[j] != key {
+				t.Errorf("GannoyIndex with cache should return %v, but %v", expect, nns)
+				break
+			}
+		}
+	}
+
+	stats, _ := gannoy.Stats()
+	if stats.Cache == nil || stats.Cache.Hits == 0 || stats.Cache.Capacity != 100 {
+		t.Errorf("GannoyIndex Stats should report cache, but %v", stats.Cache)
+	}
+}
+
+func TestGannoyIndexWithCacheRollback(t *testing.T) {
+	name := "test_gannoy_index_with_cache_rollback"
+	CreateMeta(".", name, 2, 3, 4)
+	defer os.Remove(name + ".meta")
+	defer os.Remove(name + ".bolt")
+
+	gannoy, _ := NewGannoyIndexWithOptions(name+".meta", Options{Storage: StorageFactoryOf(BOLT), CacheSize: 100})
+	defer gannoy.nodes.backend().(*BoltStorage).Close()
+	gannoy.AddItem(10, []float64{1.1, 1.2, 1.3})
+
+	gannoy.transaction(func() error {
+		gannoy.addItem(20, []float64{-1.1, -1.2, -1.3})
+		gannoy.GetAllNns([]float64{-1.1, -1.2, -1.3}, 2, -1) // cache uncommitted nodes
+		return fmt.Errorf("crash")
+	})
+	if size := gannoy.nodes.Storage.(*CacheStorage).Stats().Size; size != 0 {
+		t.Errorf("Rolled back mutation should purge cache, but %d nodes", size)
+	}
+	gannoy.AddItem(30, []float64{-1.1, -1.2, -1.3})
+	nns, _ := gannoy.GetNnsByKey(30, 2, -1)
+	if len(nns) != 2 {
+		t.Errorf("GannoyIndex with cache should return items after rollback, but %v", nns)
+	}
+}
@@ -0,0 +1,371 @@
+package gannoy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"sync"
+)
+
+var changesMagic = [4]byte{'G', 'N', 'Y', 'C'}
+
+// Change is an applied ADD, DELETE or UPDATE recorded in change log.
+type Change struct {
+	Seq    uint64    `json:"seq"`
+	Action string    `json:"action"`
+	Key    int       `json:"key"`
+	W      []float64 `json:"features,omitempty"`
+}
+
+// Number of records between marks, from which changes are read.
+const changeMarkInterval = 1024
+
+// changeLog appends applied mutations with sequence numbers.
+// Each record is seq(8) action(1) key(4) dim(4) v(8*dim) crc(4).
+type changeLog struct {
+	mu    sync.Mutex
+	file  *os.File
+	order binary.ByteOrder
+	dim   int
+	seq   uint64
+	end   int64
+	marks []changeMark // sparse index of sequence numbers to offsets
+}
+
+// changeMark is the offset of the record of seq.
+type changeMark struct {
+	seq    uint64
+	offset int64
+}
+
+func openChangeLog(filename string, dim int, h header) (*changeLog, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if h, err = initHeader(file, file, h); err != nil {
+		file.Close()
+		return nil, err
+	}
+	l := &changeLog{file: file, order: h.order(), dim: dim, end: headerSize}
+	err = readChangeRecords(io.NewSectionReader(file, headerSize, math.MaxInt64-headerSize), headerSize, h.order(), dim, func(c Change, end int64) error {
+		l.mark(c.Seq, l.end)
+		l.seq = c.Seq
+		l.end = end
+		return nil
+	})
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	// Drop a record torn by crash.
+	if err := file.Truncate(l.end); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *changeLog) close() error {
+	return l.file.Close()
+}
+
+// append records the mutation and returns its sequence number.
+func (l *changeLog) append(action, key int, w []float64) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if action == DELETE {
+		w = nil
+	}
+	b := make([]byte, 8+1+4+4+8*len(w)+4)
+	l.order.PutUint64(b[0:8], l.seq+1)
+	b[8] = byte(action)
+	l.order.PutUint32(b[9:13], uint32(key))
+	l.order.PutUint32(b[13:17], uint32(len(w)))
+	for i, x := range w {
+		l.order.PutUint64(b[17+8*i:], math.Float64bits(x))
+	}
+	l.order.PutUint32(b[len(b)-4:], crc32.Checksum(b[:len(b)-4], crcTable))
+
+	if _, err := l.file.WriteAt(b, l.end); err != nil {
+		return 0, err
+	}
+	l.seq++
+	l.mark(l.seq, l.end)
+	l.end += int64(len(b))
+	return l.seq, nil
+}
+
+// mark indexes the record of seq at every changeMarkInterval records.
+// It must be called with lock except in constructor.
+func (l *changeLog) mark(seq uint64, offset int64) {
+	if len(l.marks) == 0 || seq >= l.marks[len(l.marks)-1].seq+changeMarkInterval {
+		l.marks = append(l.marks, changeMark{seq: seq, offset: offset})
+	}
+}
+
+// read calls f with changes whose sequence number is greater than since.
+// Records are read from the nearest mark, and ones appended meanwhile are not read.
+func (l *changeLog) read(since uint64, f func(Change) error) error {
+	l.mu.Lock()
+	file, start, end := l.file, l.start(since), l.end
+	l.mu.Unlock()
+
+	return readChanges(file, start, end, l.order, l.dim, since, func(c Change, _ int64) error {
+		return f(c)
+	})
+}
+
+// readLocked calls f with changes whose sequence number is greater than since and their offsets.
+// It must be called with lock.
+func (l *changeLog) readLocked(since uint64, f func(c Change, offset int64) error) error {
+	return readChanges(l.file, l.start(since), l.end, l.order, l.dim, since, f)
+}
+
+// start returns offset of the nearest mark to read changes after since.
+// It must be called with lock.
+func (l *changeLog) start(since uint64) int64 {
+	i := sort.Search(len(l.marks), func(i int) bool { return l.marks[i].seq > since+1 })
+	if i > 0 {
+		return l.marks[i-1].offset
+	}
+	return headerSize
+}
+
+// readChanges calls f with changes between start and end whose sequence number is greater
+// than since, and offsets of them.
+func readChanges(file *os.File, start, end int64, order binary.ByteOrder, dim int, since uint64, f func(c Change, offset int64) error) error {
+	offset := start
+	return readChangeRecords(io.NewSectionReader(file, start, end-start), start, order, dim, func(c Change, next int64) error {
+		defer func() { offset = next }()
+		if c.Seq <= since {
+			return nil
+		}
+		return f(c, offset)
+	})
+}
+
+// sync flushes appended records to disk.
+func (l *changeLog) sync() error {
+	return l.file.Sync()
+}
+
+// truncate removes records whose sequence number is up to seq. Sequence numbers are continued.
+// Records left are moved into a new file, which replaces the log.
+func (l *changeLog) truncate(seq uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	start := l.end
+	err := l.readLocked(seq, func(c Change, offset int64) error {
+		start = offset
+		return errStopChanges
+	})
+	if err != nil && err != errStopChanges {
+		return err
+	}
+	if start == headerSize {
+		return nil
+	}
+	if start == l.end {
+		if err := l.file.Truncate(headerSize); err != nil {
+			return err
+		}
+		l.end = headerSize
+		l.marks = nil
+		return nil
+	}
+
+	name := l.file.Name()
+	tmp := name + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if _, err := io.Copy(out, io.NewSectionReader(l.file, 0, headerSize)); err != nil {
+		return fail(err)
+	}
+	if _, err := io.Copy(out, io.NewSectionReader(l.file, start, l.end-start)); err != nil {
+		return fail(err)
+	}
+	if err := out.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return fail(err)
+	}
+	l.file.Close()
+	l.file = out
+	l.end -= start - headerSize
+	marks := []changeMark{}
+	for _, m := range l.marks {
+		if m.offset >= start {
+			marks = append(marks, changeMark{seq: m.seq, offset: m.offset - (start - headerSize)})
+		}
+	}
+	l.marks = marks
+	return nil
+}
+
+func (l *changeLog) lastSeq() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.seq
+}
+
+// ReadChanges calls f with changes of the database whose sequence number is greater than since.
+// A record being written is not read. It scans the whole change log, so that
+// an opened index should read changes by Changes.
+func ReadChanges(metaFile string, since uint64, f func(Change) error) error {
+	m, err := loadMeta(metaFile)
+	if err != nil {
+		return err
+	}
+	defer m.close()
+	file, err := os.Open(m.changesPath())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	b := make([]byte, headerSize)
+	if _, err := io.ReadFull(file, b); err != nil {
+		return err
+	}
+	h, err := parseHeader(changesMagic, b)
+	if err != nil {
+		return err
+	}
+	if h.legacy() {
+		return fmt.Errorf("Invalid change log: %s.", m.changesPath())
+	}
+	return readChangeRecords(file, headerSize, h.order(), m.dim, func(c Change, _ int64) error {
+		if c.Seq <= since {
+			return nil
+		}
+		return f(c)
+	})
+}
+
+// readChangeRecords reads records from r at offset start until the end or a torn record.
+// f is called with the offset of the end of each record.
+func readChangeRecords(reader io.Reader, start int64, order binary.ByteOrder, dim int, f func(c Change, end int64) error) error {
+	r := bufio.NewReader(reader)
+	end := start
+	for {
+		head := make([]byte, 17)
+		if _, err := io.ReadFull(r, head); err != nil {
+			return nil
+		}
+		n := int(order.Uint32(head[13:17]))
+		if n != 0 && n != dim {
+			return nil
+		}
+		rest := make([]byte, 8*n+4)
+		if _, err := io.ReadFull(r, rest); err != nil {
+			return nil
+		}
+		b := append(head, rest...)
+		if crc32.Checksum(b[:len(b)-4], crcTable) != order.Uint32(b[len(b)-4:]) {
+			return nil
+		}
+		action := int(b[8])
+		name, err := actionName(action)
+		if err != nil {
+			return err
+		}
+		c := Change{
+			Seq:    order.Uint64(b[0:8]),
+			Action: name,
+			Key:    int(int32(order.Uint32(b[9:13]))),
+		}
+		if n > 0 {
+			c.W = make([]float64, n)
+			for i := range c.W {
+				c.W[i] = math.Float64frombits(order.Uint64(b[17+8*i:]))
+			}
+		}
+		end += int64(len(b))
+		if err := f(c, end); err != nil {
+			return err
+		}
+	}
+}
+
+// Replay applies the change. Changes must be replayed in order of sequence number
+// onto a snapshot, and changes already in the snapshot are skipped.
+// Changes applied but not recorded before a crash may be replayed again, so that
+// addition of an existing key replaces it, and removal of a missing key is regarded as applied.
+func (g *GannoyIndex) Replay(c Change) error {
+	if g.changes != nil {
+		seq := g.changes.lastSeq()
+		if c.Seq <= seq {
+			return nil
+		}
+		if c.Seq != seq+1 {
+			return fmt.Errorf("Change log gap. expect %d, but %d.", seq+1, c.Seq)
+		}
+	}
+	action, err := actionFromName(c.Action)
+	if err != nil {
+		return err
+	}
+	return g.replay(action, c.Key, c.W)
+}
+
+// replay applies the write which may have been applied already.
+func (g *GannoyIndex) replay(action, key int, w []float64) error {
+	if g.readOnly {
+		return ErrReadOnly
+	}
+	args := buildArgs{action: action, key: key, w: w, replay: true, result: make(chan error)}
+	g.buildChan <- args
+	return <-args.result
+}
+
+// logChange records the mutation if it is applied and change log is enabled.
+func (g *GannoyIndex) logChange(args buildArgs, err error) error {
+	if err != nil || g.changes == nil {
+		return err
+	}
+	_, err = g.changes.append(args.action, args.key, args.w)
+	return err
+}
+
+func actionName(action int) (string, error) {
+	switch action {
+	case ADD:
+		return "add", nil
+	case DELETE:
+		return "delete", nil
+	case UPDATE:
+		return "update", nil
+	default:
+		return "", fmt.Errorf("Unknown action: %d.", action)
+	}
+}
+
+func actionFromName(name string) (int, error) {
+	switch name {
+	case "add":
+		return ADD, nil
+	case "delete":
+		return DELETE, nil
+	case "update":
+		return UPDATE, nil
+	default:
+		return -1, fmt.Errorf("Unknown action: %s.", name)
+	}
+}
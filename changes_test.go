@@ -0,0 +1,148 @@
+package gannoy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGannoyIndexChangeLog(t *testing.T) {
+	name := "test_gannoy_index_change_log"
+	CreateMeta(".", name, 2, 3, 4)
+	defer os.Remove(name + ".meta")
+	defer os.Remove(name + ".tree")
+	defer os.Remove(name + ".leaves")
+	defer os.Remove(name + ".changes")
+
+	gannoy, _ := NewGannoyIndexWithOptions(name+".meta", Options{Random: &TestLoopRandom{max: 1}, ChangeLog: true})
+	gannoy.AddItem(0, []float64{1.1, 1.2, 1.3})
+	gannoy.AddItem(10, []float64{-1.1, -1.2, -1.3})
+	gannoy.AddItem(0, []float64{1.1, 1.2, 1.3}) // not applied
+	gannoy.UpdateItem(10, []float64{1.1, 1.2, 1.3})
+	gannoy.RemoveItem(0)
+
+	changes := []Change{}
+	err := ReadChanges(name+".meta", 1, func(c Change) error {
+		changes = append(changes, c)
+		return nil
+	})
+	if err != nil {
+		t.Errorf("ReadChanges should not return error, but %v", err)
+	}
+	expect := []Change{
+		{Seq: 2, Action: "add", Key: 10},
+		{Seq: 3, Action: "update", Key: 10},
+		{Seq: 4, Action: "delete", Key: 0},
+	}
+	if len(changes) != len(expect) {
+		t.Fatalf("ReadChanges should return %d changes, but %v", len(expect), changes)
+	}
+	for i, c := range changes {
+		if c.Seq != expect[i].Seq || c.Action != expect[i].Action || c.Key != expect[i].Key {
+			t.Errorf("ReadChanges should return %v, but %v", expect[i], c)
+		}
+	}
+	if changes[1].W[0] != 1.1 || changes[2].W != nil {
+		t.Errorf("ReadChanges should return features of add and update only, but %v", changes)
+	}
+
+	// Sequence continues after reopen, and torn record is dropped.
+	f, _ := os.OpenFile(name+".changes", os.O_WRONLY|os.O_APPEND, 0)
+	f.Write([]byte{0, 0, 0})
+	f.Close()
+	l, err := openChangeLog(name+".changes", 3, newHeader(changesMagic, BIG_ENDIAN, ANGULAR))
+	if err != nil {
+		t.Errorf("openChangeLog should not return error, but %v", err)
+	}
+	if seq, _ := l.append(ADD, 20, []float64{1.1, 1.2, 1.3}); seq != 5 {
+		t.Errorf("Change log should continue sequence %d, but %d", 5, seq)
+	}
+}
+
+func TestGannoyIndexReplay(t *testing.T) {
+	name := "test_gannoy_index_replay"
+	CreateMeta(".", name, 2, 3, 4)
+	defer os.Remove(name + ".meta")
+	defer os.Remove(name + ".tree")
+	defer os.Remove(name + ".leaves")
+	defer os.Remove(name + ".changes")
+
+	backup := "test_gannoy_index_replay_dir"
+	defer os.RemoveAll(backup)
+
+	gannoy, _ := NewGannoyIndexWithOptions(name+".meta", Options{ChangeLog: true})
+	gannoy.AddItem(0, []float64{1.1, 1.2, 1.3})
+	gannoy.Backup(backup)
+	gannoy.AddItem(10, []float64{-1.1, -1.2, -1.3})
+	gannoy.AddItem(20, []float64{1.1, 1.2, 1.3})
+	gannoy.RemoveItem(10)
+
+	restored, _ := NewGannoyIndexWithOptions(filepath.Join(backup, name+".meta"), Options{ChangeLog: true})
+	if err := restored.Replay(Change{Seq: 3, Action: "add", Key: 20, W: []float64{1.1, 1.2, 1.3}}); err == nil {
+		t.Errorf("Replay with gap should return error.")
+	}
+	err := ReadChanges(name+".meta", 0, func(c Change) error {
+		return restored.Replay(c)
+	})
+	if err != nil {
+		t.Errorf("Replay should not return error, but %v", err)
+	}
+
+	keys := restored.nodes.maps.keys()
+	if len(keys) != 2 || !restored.nodes.maps.isExist(0) || !restored.nodes.maps.isExist(20) {
+		t.Errorf("Replayed database should have keys %v, but %v", []int{0, 20}, keys)
+	}
+	if seq := restored.changes.lastSeq(); seq != 4 {
+		t.Errorf("Replayed database should have change log up to %d, but %d", 4, seq)
+	}
+}
+
+func TestGannoyIndexChangesPoll(t *testing.T) {
+	name := "test_gannoy_index_changes_poll"
+	CreateMeta(".", name, 2, 3, 4)
+	defer os.Remove(name + ".meta")
+	defer os.Remove(name + ".tree")
+	defer os.Remove(name + ".leaves")
+	defer os.Remove(name + ".changes")
+
+	gannoy, _ := NewGannoyIndexWithOptions(name+".meta", Options{ChangeLog: true})
+	for i := 0; i < 3000; i++ {
+		gannoy.changes.append(ADD, i, []float64{1.1, 1.2, float64(i)})
+	}
+
+	fds, _ := ioutil.ReadDir("/proc/self/fd")
+	for _, since := range []uint64{0, 1, 1023, 1024, 1025, 2049, 2999, 3000, 3000, 3000} {
+		set, err := gannoy.Changes(since, 10)
+		if err != nil {
+			t.Errorf("Changes should not return error, but %v", err)
+		}
+		expect := 10
+		if 3000-since < 10 {
+			expect = int(3000 - since)
+		}
+		if len(set.Changes) != expect || (expect > 0 && set.Changes[0].Seq != since+1) {
+			t.Errorf("Changes since %d should return %d changes from %d, but %v", since, expect, since+1, set.Changes)
+		}
+		if set.LastSeq != 3000 {
+			t.Errorf("Changes should return last sequence number %d, but %d", 3000, set.LastSeq)
+		}
+	}
+	after, _ := ioutil.ReadDir("/proc/self/fd")
+	if len(after) > len(fds) {
+		t.Errorf("Changes should not leave files open, but %d descriptors are left open", len(after)-len(fds))
+	}
+
+	// Marks are rebuilt on reopen.
+	gannoy.changes.close()
+	l, _ := openChangeLog(name+".changes", 3, newHeader(changesMagic, BIG_ENDIAN, ANGULAR))
+	defer l.close()
+	seqs := []uint64{}
+	l.read(2500, func(c Change) error {
+		seqs = append(seqs, c.Seq)
+		return nil
+	})
+	if len(seqs) != 500 || seqs[0] != 2501 || len(l.marks) != 3 {
+		t.Errorf("Reopened change log should read changes by marks %v, but %d changes", l.marks, len(seqs))
+	}
+}
@@ -10,12 +10,14 @@ import (
 )
 
 type Options struct {
-	Dim     int    `short:"d" long:"dim" default:"2" description:"Specify size of feature dimention."`
-	Tree    int    `short:"t" long:"tree" default:"1" description:"Specify size of index tree."`
-	K       int    `short:"K" long:"K" default:"-1" default-mask:"twice the value of dim" description:"Specify max node size in a bucket node."`
-	Path    string `short:"p" long:"path" default:"." description:"Build meta file into this directory."`
-	Maps    string `short:"m" long:"map-path" default:"" description:"Specify key and index mapping CSV file, if exist."`
-	Version bool   `short:"v" long:"version" description:"Show version"`
+	Dim      int    `short:"d" long:"dim" default:"2" description:"Specify size of feature dimention."`
+	Tree     int    `short:"t" long:"tree" default:"1" description:"Specify size of index tree."`
+	K        int    `short:"K" long:"K" default:"-1" default-mask:"twice the value of dim" description:"Specify max node size in a bucket node."`
+	Path     string `short:"p" long:"path" default:"." description:"Build meta file into this directory."`
+	Maps     string `short:"m" long:"map-path" default:"" description:"Specify key and index mapping CSV file, if exist."`
+	Encoding string `short:"e" long:"encoding" default:"float64" choice:"float64" choice:"float32" choice:"int8" description:"Specify encoding of vectors in tree file. int8 encoding uses min and max of each dimension in source."`
+	Endian   string `long:"endian" default:"big" choice:"big" choice:"little" description:"Specify byte order of meta and tree files."`
+	Version  bool   `short:"v" long:"version" description:"Show version"`
 }
 
 var opts Options
@@ -44,7 +46,19 @@ func main() {
 		K = opts.Dim * 2
 	}
 
-	converter := gannoy.NewConverter(args[0], opts.Dim, opts.Tree, K, binary.LittleEndian)
+	encoding, err := gannoy.EncodingFromName(opts.Encoding)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	endian, err := gannoy.EndianFromName(opts.Endian)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	converter := gannoy.NewConverterWithOptions(args[0], opts.Dim, opts.Tree, K, binary.LittleEndian, gannoy.MetaOptions{Encoding: encoding, Endian: endian})
 	err = converter.Convert(args[0], opts.Path, args[1], opts.Maps)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
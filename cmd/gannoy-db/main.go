@@ -28,14 +28,28 @@ import (
 )
 
 type Options struct {
-	DataDir           string `short:"d" long:"data-dir" default:"." description:"Specify the directory where the meta files are located."`
-	LogDir            string `short:"l" long:"log-dir" default-mask:"os.Stdout" description:"Specify the log output directory."`
-	LockDir           string `short:"L" long:"lock-dir" default:"." description:"Specify the lock file directory. This option is used only server-starter option."`
-	WithServerStarter bool   `short:"s" long:"server-starter" description:"Use server-starter listener for server address."`
-	ShutDownTimeout   int    `short:"t" long:"timeout" default:"10" description:"Specify the number of seconds for shutdown timeout."`
-	MaxConnections    int    `short:"m" long:"max-connections" default:"100" description:"Specify the number of max connections."`
-	Config            string `short:"c" long:"config" default:"" description:"Configuration file path."`
-	Version           bool   `short:"v" long:"version" description:"Show version"`
+	DataDir            string            `short:"d" long:"data-dir" default:"." description:"Specify the directory where the meta files are located."`
+	LogDir             string            `short:"l" long:"log-dir" default-mask:"os.Stdout" description:"Specify the log output directory."`
+	LockDir            string            `short:"L" long:"lock-dir" default:"." description:"Specify the lock file directory. This option is used only server-starter option."`
+	WithServerStarter  bool              `short:"s" long:"server-starter" description:"Use server-starter listener for server address."`
+	ShutDownTimeout    int               `short:"t" long:"timeout" default:"10" description:"Specify the number of seconds for shutdown timeout."`
+	MaxConnections     int               `short:"m" long:"max-connections" default:"100" description:"Specify the number of max connections."`
+	Storage            string            `long:"storage" default:"file" choice:"file" choice:"mmap" choice:"bolt" description:"Specify storage of nodes."`
+	CacheSize          int               `long:"cache-size" default:"0" description:"Specify the number of nodes cached per database (0 disables cache)."`
+	DatabaseCacheSize  map[string]int    `long:"database-cache-size" value-name:"DATABASE:SIZE" description:"Specify the number of nodes cached for the database. This overrides cache-size."`
+	Durability         string            `long:"durability" choice:"none" choice:"per-write" choice:"interval" default-mask:"durability of meta file" description:"Specify when written files of databases are synced to disk."`
+	DatabaseDurability map[string]string `long:"database-durability" value-name:"DATABASE:DURABILITY" description:"Specify durability of the database. This overrides durability."`
+	SyncInterval       int               `long:"sync-interval" default:"1000" description:"Specify the number of milliseconds between syncs for interval durability."`
+	Writers            int               `long:"writers" default:"1" description:"Specify the number of goroutines applying writes of each database concurrently."`
+	Async              bool              `long:"async" description:"Return 202 for writes of features once they are queued in write-ahead log. Add wait=true query to wait for them to be applied."`
+	ReadOnly           bool              `long:"read-only" description:"Open files of databases read-only and reject writes of features with 403."`
+	DatabaseReadOnly   map[string]bool   `long:"database-read-only" value-name:"DATABASE:BOOL" description:"Specify whether the database is opened read-only. This overrides read-only."`
+	ChangeLog          bool              `long:"change-log" description:"Record applied changes of databases with sequence numbers for incremental backup."`
+	Follow             string            `long:"follow" value-name:"URL" description:"Replicate databases from leader gannoy-db at this URL. Writes to this instance are rejected."`
+	FollowInterval     int               `long:"follow-interval" default:"1" description:"Specify the number of seconds between pulls of changes from leader."`
+	SnapshotDir        string            `long:"snapshot-dir" default:"snapshot" description:"Specify the directory where snapshots of databases are created."`
+	Config             string            `short:"c" long:"config" default:"" description:"Configuration file path."`
+	Version            bool              `short:"v" long:"version" description:"Show version"`
 }
 
 var opts Options
@@ -49,6 +63,10 @@ type FeatureWithKey struct {
 	W   []float64 `json:"features"`
 }
 
+type Snapshot struct {
+	Dir string `json:"dir"`
+}
+
 func main() {
 
 	// Parse option from args and configuration file.
@@ -114,7 +132,7 @@ func main() {
 	metaCh := make(chan string, len(files))
 	gannoyCh := make(chan gannoy.GannoyIndex)
 	errCh := make(chan error)
-	databases := map[string]gannoy.GannoyIndex{}
+	indexes := map[string]gannoy.GannoyIndex{}
 	var metaCount int
 	for _, file := range files {
 		if file.IsDir() || filepath.Ext(file.Name()) != ".meta" {
@@ -139,9 +157,8 @@ loop:
 	for {
 		select {
 		case gannoy := <-gannoyCh:
-			key := strings.TrimSuffix(filepath.Base(gannoy.MetaFile()), ".meta")
-			databases[key] = gannoy
-			if len(databases) >= metaCount {
+			indexes[databaseName(gannoy.MetaFile())] = gannoy
+			if len(indexes) >= metaCount {
 				close(metaCh)
 				close(gannoyCh)
 				close(errCh)
@@ -153,6 +170,33 @@ loop:
 		}
 	}
 
+	// Group shards into databases
+	databases, err := groupShards(indexes)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// Start replication from leader. Each shard follows the same shard of leader.
+	stopCh := make(chan struct{})
+	followers := map[string]*gannoy.Follower{}
+	if opts.Follow != "" {
+		for database, index := range indexes {
+			if databaseReadOnly(database) {
+				fmt.Fprintf(os.Stderr, "Read-only database %s can not follow leader.\n", database)
+				os.Exit(1)
+			}
+			index := index
+			follower, err := gannoy.NewFollower(&index, opts.Follow, database)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			followers[database] = follower
+			go follower.Run(time.Duration(opts.FollowInterval)*time.Second, stopCh)
+		}
+	}
+
 	// Define API
 	e.GET("/search", func(c echo.Context) error {
 		database := c.QueryParam("database")
@@ -169,7 +213,15 @@ loop:
 		}
 
 		gannoy := databases[database]
-		r, err := gannoy.GetNnsByKey(key, limit, -1)
+		if rerank, err := strconv.Atoi(c.QueryParam("rerank")); err == nil {
+			gannoy = gannoy.WithRerank(rerank)
+		}
+		var r []int
+		if exact, _ := strconv.ParseBool(c.QueryParam("exact")); exact {
+			r, err = gannoy.GetNnsByKeyExact(key, limit)
+		} else {
+			r, err = gannoy.GetNnsByKey(key, limit, -1)
+		}
 		if err != nil || len(r) == 0 {
 			return c.NoContent(http.StatusNotFound)
 		}
@@ -177,22 +229,76 @@ loop:
 		return c.JSON(http.StatusOK, r)
 	})
 
-	e.POST("/databases/:database/features", func(c echo.Context) error {
+	e.POST("/databases/:database/search", func(c echo.Context) error {
 		database := c.Param("database")
 		if _, ok := databases[database]; !ok {
-			return c.NoContent(http.StatusUnprocessableEntity)
+			return c.NoContent(http.StatusNotFound)
 		}
-		feature := new(FeatureWithKey)
+		limit, err := strconv.Atoi(c.QueryParam("limit"))
+		if err != nil {
+			limit = 10
+		}
+		feature := new(Feature)
 		if err := c.Bind(feature); err != nil {
 			return err
 		}
 
 		gannoy := databases[database]
-		err = gannoy.AddItem(feature.Key, feature.W)
+		if len(feature.W) != gannoy.Dim() {
+			return c.NoContent(http.StatusUnprocessableEntity)
+		}
+		var r []int
+		if exact, _ := strconv.ParseBool(c.QueryParam("exact")); exact {
+			r, err = gannoy.GetAllNnsExact(feature.W, limit)
+		} else {
+			r, err = gannoy.GetAllNns(feature.W, limit, -1)
+		}
 		if err != nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+		neighbors, err := gannoy.Neighbors(feature.W, r)
+		if err != nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+		return c.JSON(http.StatusOK, neighbors)
+	})
+
+	e.GET("/databases/:database/features/:key", func(c echo.Context) error {
+		database := c.Param("database")
+		if _, ok := databases[database]; !ok {
+			return c.NoContent(http.StatusNotFound)
+		}
+		key, err := strconv.Atoi(c.Param("key"))
+		if err != nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+		gannoy := databases[database]
+		w, err := gannoy.GetItem(key)
+		if err != nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+		return c.JSON(http.StatusOK, FeatureWithKey{Key: key, W: w})
+	})
+
+	e.POST("/databases/:database/features", func(c echo.Context) error {
+		database := c.Param("database")
+		if _, ok := databases[database]; !ok {
 			return c.NoContent(http.StatusUnprocessableEntity)
 		}
-		return c.NoContent(http.StatusOK)
+		if opts.Follow != "" {
+			return c.NoContent(http.StatusForbidden)
+		}
+		feature := new(FeatureWithKey)
+		if err := c.Bind(feature); err != nil {
+			return err
+		}
+
+		gannoy := databases[database]
+		return write(c, gannoy, func() error {
+			return gannoy.AddItem(feature.Key, feature.W)
+		}, func() (uint64, error) {
+			return gannoy.AddItemAsync(feature.Key, feature.W)
+		})
 	})
 
 	e.PUT("/databases/:database/features/:key", func(c echo.Context) error {
@@ -200,6 +306,9 @@ loop:
 		if _, ok := databases[database]; !ok {
 			return c.NoContent(http.StatusUnprocessableEntity)
 		}
+		if opts.Follow != "" {
+			return c.NoContent(http.StatusForbidden)
+		}
 		key, err := strconv.Atoi(c.Param("key"))
 		if err != nil {
 			return c.NoContent(http.StatusUnprocessableEntity)
@@ -210,11 +319,11 @@ loop:
 		}
 
 		gannoy := databases[database]
-		err = gannoy.UpdateItem(key, feature.W)
-		if err != nil {
-			return c.NoContent(http.StatusUnprocessableEntity)
-		}
-		return c.NoContent(http.StatusOK)
+		return write(c, gannoy, func() error {
+			return gannoy.UpdateItem(key, feature.W)
+		}, func() (uint64, error) {
+			return gannoy.UpdateItemAsync(key, feature.W)
+		})
 	})
 
 	e.DELETE("/databases/:database/features/:key", func(c echo.Context) error {
@@ -222,17 +331,92 @@ loop:
 		if _, ok := databases[database]; !ok {
 			return c.NoContent(http.StatusUnprocessableEntity)
 		}
+		if opts.Follow != "" {
+			return c.NoContent(http.StatusForbidden)
+		}
 		key, err := strconv.Atoi(c.Param("key"))
 		if err != nil {
 			return c.NoContent(http.StatusUnprocessableEntity)
 		}
 		gannoy := databases[database]
-		err = gannoy.RemoveItem(key)
+		return write(c, gannoy, func() error {
+			return gannoy.RemoveItem(key)
+		}, func() (uint64, error) {
+			return gannoy.RemoveItemAsync(key)
+		})
+	})
+
+	e.GET("/databases/:database/writes/:id", func(c echo.Context) error {
+		database := c.Param("database")
+		if _, ok := databases[database]; !ok {
+			return c.NoContent(http.StatusNotFound)
+		}
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
 		if err != nil {
-			return c.NoContent(http.StatusUnprocessableEntity)
+			return c.NoContent(http.StatusNotFound)
+		}
+		gannoy := databases[database]
+		status, err := gannoy.GetWriteStatus(id)
+		if err != nil {
+			return c.NoContent(http.StatusNotFound)
 		}
+		return c.JSON(http.StatusOK, status)
+	})
 
-		return c.NoContent(http.StatusOK)
+	e.GET("/databases/:database/stats", func(c echo.Context) error {
+		database := c.Param("database")
+		if _, ok := databases[database]; !ok {
+			return c.NoContent(http.StatusNotFound)
+		}
+		gannoy := databases[database]
+		stats, err := gannoy.Stats()
+		if err != nil {
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		return c.JSON(http.StatusOK, stats)
+	})
+
+	e.POST("/databases/:database/snapshot", func(c echo.Context) error {
+		database := c.Param("database")
+		if _, ok := databases[database]; !ok {
+			return c.NoContent(http.StatusNotFound)
+		}
+		dir := filepath.Join(opts.SnapshotDir, database, time.Now().Format("20060102150405"))
+		gannoy := databases[database]
+		if err := gannoy.Backup(dir); err != nil {
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		return c.JSON(http.StatusOK, Snapshot{Dir: dir})
+	})
+
+	e.GET("/databases/:database/changes", func(c echo.Context) error {
+		database := c.Param("database")
+		if _, ok := indexes[database]; !ok {
+			return c.NoContent(http.StatusNotFound)
+		}
+		since, err := strconv.ParseUint(c.QueryParam("since"), 10, 64)
+		if err != nil {
+			since = 0
+		}
+		limit, err := strconv.Atoi(c.QueryParam("limit"))
+		if err != nil {
+			limit = 1000
+		}
+		gannoy := indexes[database]
+		changes, err := gannoy.Changes(since, limit)
+		if err != nil {
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		return c.JSON(http.StatusOK, changes)
+	})
+
+	e.GET("/databases/:database/replication", func(c echo.Context) error {
+		database := c.Param("database")
+		follower, ok := followers[database]
+		if !ok {
+			return c.NoContent(http.StatusNotFound)
+		}
+		return c.JSON(http.StatusOK, follower.Status())
 	})
 
 	e.GET("/health", func(c echo.Context) error {
@@ -267,6 +451,7 @@ loop:
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, sig)
 	<-sigCh
+	close(stopCh)
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(opts.ShutDownTimeout)*time.Second)
 	defer cancel()
@@ -275,6 +460,36 @@ loop:
 	}
 }
 
+// write applies the write of features, or queues it in async mode.
+func write(c echo.Context, index gannoy.Index, apply func() error, enqueue func() (uint64, error)) error {
+	if !opts.Async {
+		if err := apply(); err == gannoy.ErrReadOnly {
+			return c.NoContent(http.StatusForbidden)
+		} else if err != nil {
+			return c.NoContent(http.StatusUnprocessableEntity)
+		}
+		return c.NoContent(http.StatusOK)
+	}
+	id, err := enqueue()
+	if err == gannoy.ErrReadOnly {
+		return c.NoContent(http.StatusForbidden)
+	} else if err != nil {
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if c.QueryParam("wait") != "true" {
+		status, err := index.GetWriteStatus(id)
+		if err != nil {
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		return c.JSON(http.StatusAccepted, status)
+	}
+	status, err := index.WaitWrite(id)
+	if err != nil || status.Status == gannoy.WriteFailed {
+		return c.NoContent(http.StatusUnprocessableEntity)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
 func initializeLog(logDir string) (*os.File, error) {
 	if logDir == "" {
 		return os.Stdout, nil
@@ -301,8 +516,30 @@ func initializeLock(lockDir string) (lockfile.Lockfile, error) {
 }
 
 func gannoyIndexInitializer(metaCh chan string, gannoyCh chan gannoy.GannoyIndex, errCh chan error) {
+	storage, err := gannoy.StorageFromName(opts.Storage)
+	if err != nil {
+		errCh <- err
+		return
+	}
 	for meta := range metaCh {
-		gannoy, err := gannoy.NewGannoyIndex(meta, gannoy.Angular{}, gannoy.RandRandom{})
+		cacheSize := opts.CacheSize
+		if size, ok := opts.DatabaseCacheSize[databaseName(meta)]; ok {
+			cacheSize = size
+		}
+		durability, err := databaseDurability(databaseName(meta))
+		if err != nil {
+			errCh <- err
+			continue
+		}
+		gannoy, err := gannoy.NewGannoyIndexWithOptions(meta, gannoy.Options{
+			Storage:    gannoy.StorageFactoryOf(storage),
+			CacheSize:  cacheSize,
+			Writers:    opts.Writers,
+			WAL:        opts.Async,
+			Durability: durability,
+			ChangeLog:  opts.ChangeLog || opts.Follow != "",
+			ReadOnly:   databaseReadOnly(databaseName(meta)),
+		})
 		if err == nil {
 			gannoyCh <- gannoy
 		} else {
@@ -310,3 +547,61 @@ func gannoyIndexInitializer(metaCh chan string, gannoyCh chan gannoy.GannoyIndex
 		}
 	}
 }
+
+// databaseDurability returns durability of the database specified by options, or nil to use its meta file.
+func databaseDurability(database string) (*gannoy.Durability, error) {
+	name := opts.Durability
+	if n, ok := opts.DatabaseDurability[database]; ok {
+		name = n
+	}
+	if name == "" {
+		return nil, nil
+	}
+	policy, err := gannoy.DurabilityFromName(name)
+	if err != nil {
+		return nil, err
+	}
+	return &gannoy.Durability{Policy: policy, Interval: time.Duration(opts.SyncInterval) * time.Millisecond}, nil
+}
+
+// databaseReadOnly returns whether the database is opened read-only.
+func databaseReadOnly(database string) bool {
+	if readOnly, ok := opts.DatabaseReadOnly[database]; ok {
+		return readOnly
+	}
+	return opts.ReadOnly
+}
+
+// groupShards returns databases of indexes, in which shards such as NAME.shard0 are
+// grouped into a sharded database NAME.
+func groupShards(indexes map[string]gannoy.GannoyIndex) (map[string]gannoy.Index, error) {
+	databases := map[string]gannoy.Index{}
+	shards := map[string][]*gannoy.GannoyIndex{}
+	for database, index := range indexes {
+		index := index
+		name, shard, ok := gannoy.ParseShardName(database)
+		if !ok {
+			databases[database] = &index
+			continue
+		}
+		for len(shards[name]) <= shard {
+			shards[name] = append(shards[name], nil)
+		}
+		shards[name][shard] = &index
+	}
+	for name, indexes := range shards {
+		if _, ok := databases[name]; ok {
+			return nil, fmt.Errorf("Database %s is both sharded and not sharded.", name)
+		}
+		sharded, err := gannoy.NewShardedIndex(indexes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		databases[name] = sharded
+	}
+	return databases, nil
+}
+
+func databaseName(meta string) string {
+	return strings.TrimSuffix(filepath.Base(meta), ".meta")
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/netutil"
+
+	flags "github.com/jessevdk/go-flags"
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+	"github.com/labstack/gommon/log"
+	"github.com/monochromegane/conflag"
+	"github.com/monochromegane/gannoy"
+)
+
+type Options struct {
+	Shards          []string `long:"shard" value-name:"URL" description:"Specify gannoy-db of each shard in order. This option can be specified multiple times."`
+	Listen          string   `long:"listen" default:":1323" description:"Specify the address to listen."`
+	LogDir          string   `short:"l" long:"log-dir" default-mask:"os.Stdout" description:"Specify the log output directory."`
+	ShutDownTimeout int      `short:"t" long:"timeout" default:"10" description:"Specify the number of seconds for shutdown timeout."`
+	MaxConnections  int      `short:"m" long:"max-connections" default:"100" description:"Specify the number of max connections."`
+	RequestTimeout  int      `long:"request-timeout" default:"10" description:"Specify the number of seconds for timeout of requests to shards."`
+	Config          string   `short:"c" long:"config" default:"" description:"Configuration file path."`
+	Version         bool     `short:"v" long:"version" description:"Show version"`
+}
+
+var opts Options
+
+type Feature struct {
+	W []float64 `json:"features"`
+}
+
+type FeatureWithKey struct {
+	Key int       `json:"key"`
+	W   []float64 `json:"features"`
+}
+
+var client *http.Client
+
+func main() {
+
+	// Parse option from args and configuration file.
+	conflag.LongHyphen = true
+	conflag.BoolValue = false
+	parser := flags.NewParser(&opts, flags.Default)
+	_, err := parser.ParseArgs(os.Args[1:])
+	if err != nil {
+		os.Exit(1)
+	}
+	if opts.Version {
+		fmt.Printf("%s version %s\n", parser.Name, gannoy.VERSION)
+		os.Exit(0)
+	}
+	if opts.Config != "" {
+		if args, err := conflag.ArgsFrom(opts.Config); err == nil {
+			if _, err := parser.ParseArgs(args); err != nil {
+				os.Exit(1)
+			}
+		}
+	}
+	_, err = parser.ParseArgs(os.Args[1:])
+	if err != nil {
+		os.Exit(1)
+	}
+	if len(opts.Shards) == 0 {
+		fmt.Fprintln(os.Stderr, "Do not exist shards.")
+		os.Exit(1)
+	}
+	client = &http.Client{Timeout: time.Duration(opts.RequestTimeout) * time.Second}
+
+	e := echo.New()
+
+	// initialize log
+	l, err := initializeLog(opts.LogDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	e.Logger.SetLevel(log.INFO)
+	e.Logger.SetOutput(l)
+	e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{Output: l}))
+
+	// Define API
+	e.GET("/search", func(c echo.Context) error {
+		database := c.QueryParam("database")
+		key, err := strconv.Atoi(c.QueryParam("key"))
+		if err != nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+		limit, err := strconv.Atoi(c.QueryParam("limit"))
+		if err != nil {
+			limit = 10
+		}
+
+		// Vector of the key is kept by its shard only.
+		feature := new(FeatureWithKey)
+		status, err := request(http.MethodGet, shardURL(key, "/databases/%s/features/%d", database, key), nil, feature)
+		if err != nil || status != http.StatusOK {
+			return c.NoContent(http.StatusNotFound)
+		}
+
+		body, _ := json.Marshal(Feature{W: feature.W})
+		results := make([][]gannoy.Neighbor, len(opts.Shards))
+		errs := make([]error, len(opts.Shards))
+		var wg sync.WaitGroup
+		for i, shard := range opts.Shards {
+			wg.Add(1)
+			go func(i int, shard string) {
+				defer wg.Done()
+				url := fmt.Sprintf("%s/databases/%s/search?limit=%d&exact=%s", shard, database, limit, c.QueryParam("exact"))
+				status, err := request(http.MethodPost, url, body, &results[i])
+				if err == nil && status != http.StatusOK {
+					err = fmt.Errorf("Shard %s returned %d.", shard, status)
+				}
+				errs[i] = err
+			}(i, shard)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil {
+				e.Logger.Error(err)
+				return c.NoContent(http.StatusBadGateway)
+			}
+		}
+
+		r := gannoy.MergeNeighbors(results, limit)
+		if len(r) == 0 {
+			return c.NoContent(http.StatusNotFound)
+		}
+		return c.JSON(http.StatusOK, r)
+	})
+
+	e.POST("/databases/:database/features", func(c echo.Context) error {
+		feature := new(FeatureWithKey)
+		if err := c.Bind(feature); err != nil {
+			return err
+		}
+		body, _ := json.Marshal(feature)
+		return forward(c, http.MethodPost, shardOf(feature.Key), fmt.Sprintf("/databases/%s/features", c.Param("database")), body)
+	})
+
+	e.PUT("/databases/:database/features/:key", func(c echo.Context) error {
+		key, err := strconv.Atoi(c.Param("key"))
+		if err != nil {
+			return c.NoContent(http.StatusUnprocessableEntity)
+		}
+		feature := new(Feature)
+		if err := c.Bind(feature); err != nil {
+			return err
+		}
+		body, _ := json.Marshal(feature)
+		return forward(c, http.MethodPut, shardOf(key), fmt.Sprintf("/databases/%s/features/%d", c.Param("database"), key), body)
+	})
+
+	e.DELETE("/databases/:database/features/:key", func(c echo.Context) error {
+		key, err := strconv.Atoi(c.Param("key"))
+		if err != nil {
+			return c.NoContent(http.StatusUnprocessableEntity)
+		}
+		return forward(c, http.MethodDelete, shardOf(key), fmt.Sprintf("/databases/%s/features/%d", c.Param("database"), key), nil)
+	})
+
+	e.GET("/databases/:database/writes/:id", func(c echo.Context) error {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+		// Ids of writes are numbered in each shard, and interleaved by shard.
+		n := uint64(len(opts.Shards))
+		return forward(c, http.MethodGet, int(id%n), fmt.Sprintf("/databases/%s/writes/%d", c.Param("database"), id/n), nil)
+	})
+
+	e.GET("/health", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	// Start server
+	listener, err := net.Listen("tcp", opts.Listen)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	e.Listener = netutil.LimitListener(listener, opts.MaxConnections)
+
+	go func() {
+		if err := e.Start(""); err != nil {
+			e.Logger.Info("shutting down the server")
+		}
+	}()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	<-sigCh
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(opts.ShutDownTimeout)*time.Second)
+	defer cancel()
+	if err := e.Shutdown(ctx); err != nil {
+		e.Logger.Fatal(err)
+	}
+}
+
+func initializeLog(logDir string) (*os.File, error) {
+	if logDir == "" {
+		return os.Stdout, nil
+	}
+	if err := os.MkdirAll(logDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(filepath.Join(logDir, "gannoy-router.log"), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+}
+
+// shardURL returns URL of the path on the shard of the key.
+func shardURL(key int, format string, args ...interface{}) string {
+	return opts.Shards[shardOf(key)] + fmt.Sprintf(format, args...)
+}
+
+func shardOf(key int) int {
+	return gannoy.ShardOf(key, len(opts.Shards))
+}
+
+// request sends JSON body, and decodes JSON response into result if it is OK.
+func request(method, url string, body []byte, result interface{}) (int, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK || result == nil {
+		ioutil.ReadAll(res.Body)
+		return res.StatusCode, nil
+	}
+	return res.StatusCode, json.NewDecoder(res.Body).Decode(result)
+}
+
+// forward sends the request to the path on the shard with its query, and returns the response of the shard.
+// Ids of writes in the response are interleaved by shard, as ShardedIndex does.
+func forward(c echo.Context, method string, shard int, path string, body []byte) error {
+	url := opts.Shards[shard] + path
+	if query := c.Request().URL.RawQuery; query != "" {
+		url += "?" + query
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return c.NoContent(http.StatusBadGateway)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		return c.NoContent(http.StatusBadGateway)
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return c.NoContent(http.StatusBadGateway)
+	}
+
+	var status gannoy.WriteStatus
+	if len(b) > 0 && json.Unmarshal(b, &status) == nil && status.Status != "" {
+		status.ID = status.ID*uint64(len(opts.Shards)) + uint64(shard)
+		b, _ = json.Marshal(status)
+	}
+	// Length is set by the body written.
+	res.Header.Del("Content-Length")
+	for name, values := range res.Header {
+		for _, value := range values {
+			c.Response().Header().Add(name, value)
+		}
+	}
+	if len(b) == 0 {
+		return c.NoContent(res.StatusCode)
+	}
+	return c.Stream(res.StatusCode, res.Header.Get("Content-Type"), bytes.NewReader(b))
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"path/filepath"
+	"time"
 
 	flags "github.com/jessevdk/go-flags"
 	"github.com/monochromegane/gannoy"
@@ -13,14 +18,82 @@ type Options struct {
 }
 
 type CreateCommand struct {
-	Dim  int    `short:"d" long:"dim" default:"2" description:"Specify size of feature dimention."`
-	Tree int    `short:"t" long:"tree" default:"1" description:"Specify size of index tree."`
-	K    int    `short:"K" long:"K" default:"-1" default-mask:"twice the value of dim" description:"Specify max node size in a bucket node."`
-	Path string `short:"p" long:"path" default:"." description:"Build meta file into this directory."`
+	Dim          int     `short:"d" long:"dim" default:"2" description:"Specify size of feature dimention."`
+	Tree         int     `short:"t" long:"tree" default:"1" description:"Specify size of index tree."`
+	K            int     `short:"K" long:"K" default:"-1" default-mask:"twice the value of dim" description:"Specify max node size in a bucket node."`
+	Path         string  `short:"p" long:"path" default:"." description:"Build meta file into this directory."`
+	Encoding     string  `short:"e" long:"encoding" default:"float64" choice:"float64" choice:"float32" choice:"int8" description:"Specify encoding of vectors in tree file."`
+	Min          float64 `long:"min" default:"-1.0" description:"Specify min of feature values for int8 encoding."`
+	Max          float64 `long:"max" default:"1.0" description:"Specify max of feature values for int8 encoding."`
+	Endian       string  `long:"endian" default:"big" choice:"big" choice:"little" description:"Specify byte order of meta and tree files."`
+	Shards       int     `long:"shards" default:"1" description:"Split the database into this number of shards by hash of keys."`
+	Durability   string  `long:"durability" default:"none" choice:"none" choice:"per-write" choice:"interval" description:"Specify when written files are synced to disk."`
+	SyncInterval int     `long:"sync-interval" default:"1000" description:"Specify the number of milliseconds between syncs for interval durability."`
+}
+
+type StatsCommand struct {
+	Path string `short:"p" long:"path" default:"." description:"Load meta file from this directory."`
+}
+
+type DumpCommand struct {
+	Format     string `short:"f" long:"format" default:"dot" choice:"dot" choice:"json" description:"Specify output format."`
+	Tree       int    `short:"t" long:"tree" default:"-1" default-mask:"all trees" description:"Specify index of tree to dump."`
+	Depth      int    `short:"D" long:"depth" default:"-1" default-mask:"unlimited" description:"Truncate tree at this depth."`
+	OmitVector bool   `short:"o" long:"omit-vector" description:"Do not include vectors."`
+	Path       string `short:"p" long:"path" default:"." description:"Load meta file from this directory."`
+}
+
+type EvalCommand struct {
+	Samples int    `short:"s" long:"samples" default:"100" description:"Specify number of query keys to sample."`
+	Limit   int    `short:"n" long:"limit" default:"10" description:"Specify number of neighbors (k of recall@k)."`
+	SearchK []int  `short:"k" long:"search-k" default:"-1" default-mask:"limit * tree" description:"Specify search_k values to sweep. This option can be specified multiple times."`
+	Path    string `short:"p" long:"path" default:"." description:"Load meta file from this directory."`
+}
+
+type PQTrainCommand struct {
+	M         int    `short:"m" long:"subspaces" default:"8" description:"Specify number of subspaces. dim must be a multiple of it."`
+	Samples   int    `short:"s" long:"samples" default:"10000" description:"Specify number of leaves used for training."`
+	Iteration int    `short:"i" long:"iteration" default:"25" description:"Specify number of k-means iteration."`
+	Path      string `short:"p" long:"path" default:"." description:"Load and build meta files in this directory."`
+}
+
+type MigrateCommand struct {
+	Endian string `long:"endian" choice:"big" choice:"little" default-mask:"current byte order" description:"Specify byte order of migrated files."`
+	Path   string `short:"p" long:"path" default:"." description:"Load meta file from this directory."`
+}
+
+type SnapshotCommand struct {
+	Server string `short:"s" long:"server" description:"Request snapshot to running gannoy-db at this URL (e.g. http://localhost:1323)."`
+	Output string `short:"o" long:"output" description:"Copy database files into this directory. This option is used without server option."`
+	Path   string `short:"p" long:"path" default:"." description:"Load meta file from this directory."`
+}
+
+type RestoreCommand struct {
+	Input string `short:"i" long:"input" required:"true" description:"Restore database files from this snapshot directory."`
+	Path  string `short:"p" long:"path" default:"." description:"Restore meta file into this directory."`
+}
+
+type ChangesCommand struct {
+	Since uint64 `long:"since" default:"0" description:"Export changes whose sequence number is greater than this."`
+	Path  string `short:"p" long:"path" default:"." description:"Load meta file from this directory."`
+}
+
+type ReplayCommand struct {
+	Input string `short:"i" long:"input" default-mask:"stdin" description:"Read changes exported by changes command from this file."`
+	Path  string `short:"p" long:"path" default:"." description:"Load meta file from this directory."`
 }
 
 var opts Options
 var createCommand CreateCommand
+var statsCommand StatsCommand
+var dumpCommand DumpCommand
+var evalCommand EvalCommand
+var pqTrainCommand PQTrainCommand
+var migrateCommand MigrateCommand
+var snapshotCommand SnapshotCommand
+var restoreCommand RestoreCommand
+var changesCommand ChangesCommand
+var replayCommand ReplayCommand
 
 func (c *CreateCommand) Execute(args []string) error {
 	if len(args) != 1 {
@@ -33,7 +106,36 @@ func (c *CreateCommand) Execute(args []string) error {
 	if K == -1 {
 		K = c.Dim * 2
 	}
-	err := gannoy.CreateMeta(c.Path, args[0], c.Tree, c.Dim, K)
+	encoding, err := gannoy.EncodingFromName(c.Encoding)
+	if err != nil {
+		return err
+	}
+	endian, err := gannoy.EndianFromName(c.Endian)
+	if err != nil {
+		return err
+	}
+	durability, err := gannoy.DurabilityFromName(c.Durability)
+	if err != nil {
+		return err
+	}
+	options := gannoy.MetaOptions{
+		Encoding:   encoding,
+		Endian:     endian,
+		Durability: gannoy.Durability{Policy: durability, Interval: time.Duration(c.SyncInterval) * time.Millisecond},
+	}
+	if encoding == gannoy.INT8 {
+		options.Min = make([]float64, c.Dim)
+		options.Max = make([]float64, c.Dim)
+		for i := 0; i < c.Dim; i++ {
+			options.Min[i] = c.Min
+			options.Max[i] = c.Max
+		}
+	}
+	if c.Shards > 1 {
+		err = gannoy.CreateShardedMeta(c.Path, args[0], c.Shards, c.Tree, c.Dim, K, options)
+	} else {
+		err = gannoy.CreateMetaWithOptions(c.Path, args[0], c.Tree, c.Dim, K, options)
+	}
 	if err != nil {
 		return err
 	}
@@ -43,6 +145,222 @@ func (c *CreateCommand) Usage() string {
 	return "[create-OPTIONS] DATABASE"
 }
 
+func (c *StatsCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("database name not specified.")
+	}
+	index, err := gannoy.NewGannoyIndexWithOptions(filepath.Join(c.Path, args[0]+".meta"), gannoy.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	stats, err := index.Stats()
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+func (c *StatsCommand) Usage() string {
+	return "[stats-OPTIONS] DATABASE"
+}
+
+func (c *DumpCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("database name not specified.")
+	}
+	index, err := gannoy.NewGannoyIndexWithOptions(filepath.Join(c.Path, args[0]+".meta"), gannoy.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	options := gannoy.DumpOptions{
+		Tree:       c.Tree,
+		Depth:      c.Depth,
+		OmitVector: c.OmitVector,
+	}
+	if c.Format == "json" {
+		return index.DumpJSON(os.Stdout, options)
+	}
+	return index.DumpDot(os.Stdout, options)
+}
+
+func (c *DumpCommand) Usage() string {
+	return "[dump-OPTIONS] DATABASE"
+}
+
+func (c *EvalCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("database name not specified.")
+	}
+	index, err := gannoy.NewGannoyIndexWithOptions(filepath.Join(c.Path, args[0]+".meta"), gannoy.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	results, err := index.Evaluate(gannoy.EvalOptions{
+		Samples:  c.Samples,
+		N:        c.Limit,
+		SearchKs: c.SearchK,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%10s %10s %12s %12s %12s %12s\n", "search_k", "recall", "p50", "p90", "p99", "max")
+	for _, r := range results {
+		fmt.Printf("%10d %10.4f %12v %12v %12v %12v\n", r.SearchK, r.Recall, r.P50, r.P90, r.P99, r.Max)
+	}
+	return nil
+}
+
+func (c *EvalCommand) Usage() string {
+	return "[eval-OPTIONS] DATABASE"
+}
+
+func (c *PQTrainCommand) Execute(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("source and destination database name not specified.")
+	}
+	index, err := gannoy.NewGannoyIndexWithOptions(filepath.Join(c.Path, args[0]+".meta"), gannoy.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	codebook, err := index.TrainPQ(gannoy.PQOptions{
+		M:         c.M,
+		Samples:   c.Samples,
+		Iteration: c.Iteration,
+	})
+	if err != nil {
+		return err
+	}
+	return index.Rebuild(c.Path, args[1], gannoy.MetaOptions{Encoding: gannoy.PQ, Codebook: &codebook})
+}
+
+func (c *PQTrainCommand) Usage() string {
+	return "[pq-train-OPTIONS] SRC_DATABASE DEST_DATABASE"
+}
+
+func (c *MigrateCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("database name not specified.")
+	}
+	meta := filepath.Join(c.Path, args[0]+".meta")
+	if c.Endian == "" {
+		return gannoy.Migrate(meta)
+	}
+	endian, err := gannoy.EndianFromName(c.Endian)
+	if err != nil {
+		return err
+	}
+	return gannoy.MigrateWithOptions(meta, gannoy.MigrateOptions{Endian: endian})
+}
+
+func (c *MigrateCommand) Usage() string {
+	return "[migrate-OPTIONS] DATABASE"
+}
+
+func (c *SnapshotCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("database name not specified.")
+	}
+	if c.Server != "" {
+		res, err := http.Post(fmt.Sprintf("%s/databases/%s/snapshot", c.Server, args[0]), "application/json", nil)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("Snapshot failed: %s.", res.Status)
+		}
+		var snapshot struct {
+			Dir string `json:"dir"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&snapshot); err != nil {
+			return err
+		}
+		fmt.Println(snapshot.Dir)
+		return nil
+	}
+	if c.Output == "" {
+		return fmt.Errorf("output directory not specified.")
+	}
+	index, err := gannoy.NewGannoyIndexWithOptions(filepath.Join(c.Path, args[0]+".meta"), gannoy.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	if err := index.Backup(c.Output); err != nil {
+		return err
+	}
+	fmt.Println(c.Output)
+	return nil
+}
+
+func (c *SnapshotCommand) Usage() string {
+	return "[snapshot-OPTIONS] DATABASE"
+}
+
+func (c *RestoreCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("database name not specified.")
+	}
+	return gannoy.Restore(c.Input, c.Path, args[0])
+}
+
+func (c *RestoreCommand) Usage() string {
+	return "[restore-OPTIONS] DATABASE"
+}
+
+func (c *ChangesCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("database name not specified.")
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	return gannoy.ReadChanges(filepath.Join(c.Path, args[0]+".meta"), c.Since, func(change gannoy.Change) error {
+		return encoder.Encode(change)
+	})
+}
+
+func (c *ChangesCommand) Usage() string {
+	return "[changes-OPTIONS] DATABASE"
+}
+
+func (c *ReplayCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("database name not specified.")
+	}
+	input := os.Stdin
+	if c.Input != "" {
+		f, err := os.Open(c.Input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		input = f
+	}
+	index, err := gannoy.NewGannoyIndexWithOptions(filepath.Join(c.Path, args[0]+".meta"), gannoy.Options{ChangeLog: true})
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(input)
+	for {
+		var change gannoy.Change
+		if err := decoder.Decode(&change); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := index.Replay(change); err != nil {
+			return fmt.Errorf("Replay of change %d failed: %v", change.Seq, err)
+		}
+	}
+}
+
+func (c *ReplayCommand) Usage() string {
+	return "[replay-OPTIONS] DATABASE"
+}
+
 func main() {
 	parser := flags.NewParser(&opts, flags.HelpFlag|flags.PassDoubleDash) // exclude PrintError
 	parser.Name = "gannoy"
@@ -51,6 +369,42 @@ func main() {
 		"Create database",
 		"The create command creates a meta file for the database.",
 		&createCommand)
+	parser.AddCommand("stats",
+		"Show database statistics",
+		"The stats command reports tree depth, bucket fill ratio, item count and file size of the database.",
+		&statsCommand)
+	parser.AddCommand("dump",
+		"Dump tree structure",
+		"The dump command exports the split structure of the database as Graphviz DOT or JSON.",
+		&dumpCommand)
+	parser.AddCommand("eval",
+		"Evaluate search recall",
+		"The eval command compares approximate search results with exact brute-force results and reports recall@k and latency for each search_k.",
+		&evalCommand)
+	parser.AddCommand("pq-train",
+		"Train product quantization codebook",
+		"The pq-train command trains product quantization codebook from sampled leaves of the source database and builds the destination database encoded by it.",
+		&pqTrainCommand)
+	parser.AddCommand("migrate",
+		"Migrate database format",
+		"The migrate command rewrites meta and tree files of the database into the current format with header and checksums, or into another byte order. Stop gannoy-db before migration.",
+		&migrateCommand)
+	parser.AddCommand("snapshot",
+		"Snapshot database",
+		"The snapshot command copies meta, tree and auxiliary files of the database consistently. With server option, running gannoy-db pauses writes and creates the snapshot in its snapshot directory.",
+		&snapshotCommand)
+	parser.AddCommand("restore",
+		"Restore database from snapshot",
+		"The restore command copies files of the database from the snapshot directory into the data directory. Stop gannoy-db before restore.",
+		&restoreCommand)
+	parser.AddCommand("changes",
+		"Export change log",
+		"The changes command exports applied changes of the database recorded by gannoy-db with change-log option as JSON lines.",
+		&changesCommand)
+	parser.AddCommand("replay",
+		"Replay change log",
+		"The replay command applies changes exported by changes command onto the database restored from a snapshot. Stop gannoy-db before replay.",
+		&replayCommand)
 	_, err := parser.Parse()
 	if err != nil {
 		if opts.Version && err.(*flags.Error).Type == flags.ErrCommandRequired {
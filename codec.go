@@ -0,0 +1,160 @@
+package gannoy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// codec encodes a vector into fixed size bytes of the tree file.
+type codec interface {
+	size() int
+	encode([]byte, []float64)
+	decode([]byte) []float64
+}
+
+func newCodec(encoding, dim int, order binary.ByteOrder) (codec, error) {
+	switch encoding {
+	case FLOAT64:
+		return float64Codec{dim: dim, order: order}, nil
+	case FLOAT32:
+		return float32Codec{dim: dim, order: order}, nil
+	case INT8:
+		return nil, fmt.Errorf("int8 encoding requires min and max of each dimension.")
+	case PQ:
+		return nil, fmt.Errorf("pq encoding requires codebook.")
+	default:
+		return nil, fmt.Errorf("Unknown encoding: %d.", encoding)
+	}
+}
+
+type float64Codec struct {
+	dim   int
+	order binary.ByteOrder
+}
+
+func (c float64Codec) size() int {
+	return 8 * c.dim
+}
+
+func (c float64Codec) encode(b []byte, v []float64) {
+	for i, x := range v {
+		c.order.PutUint64(b[i*8:i*8+8], math.Float64bits(x))
+	}
+}
+
+func (c float64Codec) decode(b []byte) []float64 {
+	return bytesToFloat64s(c.order, b[:c.size()])
+}
+
+type float32Codec struct {
+	dim   int
+	order binary.ByteOrder
+}
+
+func (c float32Codec) size() int {
+	return 4 * c.dim
+}
+
+func (c float32Codec) encode(b []byte, v []float64) {
+	for i, x := range v {
+		c.order.PutUint32(b[i*4:i*4+4], math.Float32bits(float32(x)))
+	}
+}
+
+func (c float32Codec) decode(b []byte) []float64 {
+	floats := make([]float64, c.dim)
+	for i := 0; i < c.dim; i++ {
+		floats[i] = float64(math.Float32frombits(c.order.Uint32(b[i*4 : i*4+4])))
+	}
+	return floats
+}
+
+// int8Codec quantizes each element into 256 levels between min and max of the dimension.
+type int8Codec struct {
+	dim int
+	min []float64
+	max []float64
+}
+
+func newInt8Codec(dim int, min, max []float64) (codec, error) {
+	if len(min) != dim || len(max) != dim {
+		return nil, fmt.Errorf("min and max should have %d elements, but %d and %d.", dim, len(min), len(max))
+	}
+	for i := 0; i < dim; i++ {
+		if min[i] > max[i] {
+			return nil, fmt.Errorf("min should not be greater than max, but %f > %f at %d.", min[i], max[i], i)
+		}
+	}
+	return int8Codec{dim: dim, min: min, max: max}, nil
+}
+
+// Split planes of angular distance are unit vectors.
+func newUnitInt8Codec(dim int) codec {
+	min := make([]float64, dim)
+	max := make([]float64, dim)
+	for i := 0; i < dim; i++ {
+		min[i] = -1.0
+		max[i] = 1.0
+	}
+	return int8Codec{dim: dim, min: min, max: max}
+}
+
+func (c int8Codec) size() int {
+	return c.dim
+}
+
+func (c int8Codec) encode(b []byte, v []float64) {
+	for i, x := range v {
+		q := -128.0
+		if width := c.max[i] - c.min[i]; width > 0 {
+			q = math.Floor((x-c.min[i])/width*255+0.5) - 128
+		}
+		if q < -128 {
+			q = -128
+		} else if q > 127 {
+			q = 127
+		}
+		b[i] = byte(int8(q))
+	}
+}
+
+func (c int8Codec) decode(b []byte) []float64 {
+	floats := make([]float64, c.dim)
+	for i := 0; i < c.dim; i++ {
+		q := float64(int8(b[i])) + 128
+		floats[i] = c.min[i] + q/255*(c.max[i]-c.min[i])
+	}
+	return floats
+}
+
+func encodingName(encoding int) string {
+	switch encoding {
+	case FLOAT64:
+		return "float64"
+	case FLOAT32:
+		return "float32"
+	case INT8:
+		return "int8"
+	case PQ:
+		return "pq"
+	default:
+		return "unknown"
+	}
+}
+
+// EncodingFromName returns encoding constant from name such as "float32".
+func EncodingFromName(name string) (int, error) {
+	switch name {
+	case "float64":
+		return FLOAT64, nil
+	case "float32":
+		return FLOAT32, nil
+	case "int8":
+		return INT8, nil
+	case "pq":
+		return PQ, nil
+	default:
+		return -1, fmt.Errorf("Unknown encoding: %s.", name)
+	}
+}
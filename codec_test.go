@@ -0,0 +1,81 @@
+package gannoy
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestFloat64Codec(t *testing.T) {
+	codec, _ := newCodec(FLOAT64, 3, binary.BigEndian)
+	if codec.size() != 24 {
+		t.Errorf("float64 codec size should be 24, but %d", codec.size())
+	}
+	v := []float64{1.1, -1.2, 1.3}
+	b := make([]byte, codec.size())
+	codec.encode(b, v)
+	for i, x := range codec.decode(b) {
+		if x != v[i] {
+			t.Errorf("float64 codec should decode %v, but %v", v[i], x)
+		}
+	}
+}
+
+func TestFloat32Codec(t *testing.T) {
+	codec, _ := newCodec(FLOAT32, 3, binary.BigEndian)
+	if codec.size() != 12 {
+		t.Errorf("float32 codec size should be 12, but %d", codec.size())
+	}
+	v := []float64{1.1, -1.2, 1.3}
+	b := make([]byte, codec.size())
+	codec.encode(b, v)
+	for i, x := range codec.decode(b) {
+		if math.Abs(x-v[i]) > 1e-6 {
+			t.Errorf("float32 codec should decode %v, but %v", v[i], x)
+		}
+	}
+}
+
+func TestUnknownCodec(t *testing.T) {
+	_, err := newCodec(-1, 3, binary.BigEndian)
+	if err == nil {
+		t.Errorf("newCodec with unknown encoding should return error.")
+	}
+}
+
+func TestEncodingFromName(t *testing.T) {
+	for _, encoding := range []int{FLOAT64, FLOAT32} {
+		e, err := EncodingFromName(encodingName(encoding))
+		if err != nil || e != encoding {
+			t.Errorf("EncodingFromName should return %d, but %d", encoding, e)
+		}
+	}
+	if _, err := EncodingFromName("unknown"); err == nil {
+		t.Errorf("EncodingFromName with unknown name should return error.")
+	}
+}
+
+func TestInt8Codec(t *testing.T) {
+	_, err := newInt8Codec(3, []float64{0.0, 0.0}, []float64{1.0, 1.0})
+	if err == nil {
+		t.Errorf("int8 codec with wrong size of min and max should return error.")
+	}
+	_, err = newInt8Codec(2, []float64{1.0, 0.0}, []float64{0.0, 1.0})
+	if err == nil {
+		t.Errorf("int8 codec with min greater than max should return error.")
+	}
+
+	codec, _ := newInt8Codec(3, []float64{-2.0, 0.0, 1.0}, []float64{2.0, 10.0, 1.0})
+	if codec.size() != 3 {
+		t.Errorf("int8 codec size should be 3, but %d", codec.size())
+	}
+	v := []float64{1.1, 20.0, 1.0}
+	expects := []float64{1.1, 10.0, 1.0} // clamped by max
+	b := make([]byte, codec.size())
+	codec.encode(b, v)
+	for i, x := range codec.decode(b) {
+		if math.Abs(x-expects[i]) > 4.0/255 {
+			t.Errorf("int8 codec should decode %v, but %v", expects[i], x)
+		}
+	}
+}
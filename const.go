@@ -4,9 +4,26 @@ const (
 	ADD int = iota
 	DELETE
 	UPDATE
+	BACKUP
 )
 
 const (
 	ASC int = iota
 	DESC
 )
+
+// Storages of nodes.
+const (
+	FILE int = iota
+	MMAP
+	MEMORY
+	BOLT
+)
+
+// Encodings of vectors in tree file.
+const (
+	FLOAT64 int = iota
+	FLOAT32
+	INT8
+	PQ
+)
@@ -13,19 +13,25 @@ import (
 )
 
 func NewConverter(from string, dim, tree, K int, order binary.ByteOrder) Converter {
+	return NewConverterWithOptions(from, dim, tree, K, order, MetaOptions{Encoding: FLOAT64})
+}
+
+func NewConverterWithOptions(from string, dim, tree, K int, order binary.ByteOrder, opts MetaOptions) Converter {
 	if filepath.Ext(from) == ".csv" {
 		return csvConverter{
-			dim:   dim,
-			tree:  tree,
-			K:     K,
-			order: order,
+			dim:     dim,
+			tree:    tree,
+			K:       K,
+			order:   order,
+			options: opts,
 		}
 	} else {
 		return converter{
-			dim:   dim,
-			tree:  tree,
-			K:     K,
-			order: order,
+			dim:     dim,
+			tree:    tree,
+			K:       K,
+			order:   order,
+			options: opts,
 		}
 	}
 }
@@ -35,10 +41,11 @@ type Converter interface {
 }
 
 type converter struct {
-	dim   int
-	tree  int
-	K     int
-	order binary.ByteOrder
+	dim     int
+	tree    int
+	K       int
+	order   binary.ByteOrder
+	options MetaOptions
 }
 
 func (c converter) Convert(from, path, to, mapPath string) error {
@@ -56,16 +63,6 @@ func (c converter) Convert(from, path, to, mapPath string) error {
 		}
 	}
 
-	err = CreateMeta(path, to, c.tree, c.dim, c.K)
-	if err != nil {
-		return err
-	}
-
-	gannoy, err := NewGannoyIndex(filepath.Join(path, to+".meta"), Angular{}, RandRandom{})
-	if err != nil {
-		return err
-	}
-
 	stat, _ := ann.Stat()
 	count := int(stat.Size() / c.nodeSize())
 
@@ -102,7 +99,7 @@ func (c converter) Convert(from, path, to, mapPath string) error {
 		keys[i] = key
 		vecs[i] = vec
 	}
-	return gannoy.AddItems(keys, vecs)
+	return buildDatabase(path, to, c.tree, c.dim, c.K, c.options, keys, vecs)
 }
 
 func (c converter) offset(index int) int64 {
@@ -147,10 +144,11 @@ func (c converter) initializeMaps(path string) (map[int]int, error) {
 }
 
 type csvConverter struct {
-	dim   int
-	tree  int
-	K     int
-	order binary.ByteOrder
+	dim     int
+	tree    int
+	K       int
+	order   binary.ByteOrder
+	options MetaOptions
 }
 
 func (c csvConverter) Convert(from, path, to, mapPath string) error {
@@ -160,15 +158,6 @@ func (c csvConverter) Convert(from, path, to, mapPath string) error {
 	}
 	defer file.Close()
 
-	err = CreateMeta(path, to, c.tree, c.dim, c.K)
-	if err != nil {
-		return err
-	}
-
-	gannoy, err := NewGannoyIndex(filepath.Join(path, to+".meta"), Angular{}, RandRandom{})
-	if err != nil {
-		return err
-	}
 	reader := csv.NewReader(file)
 
 	keys := []int{}
@@ -198,5 +187,59 @@ func (c csvConverter) Convert(from, path, to, mapPath string) error {
 		keys = append(keys, key)
 		vecs = append(vecs, vec)
 	}
+	return buildDatabase(path, to, c.tree, c.dim, c.K, c.options, keys, vecs)
+}
+
+// Rebuild creates a new database with options from all items of this database.
+func (g *GannoyIndex) Rebuild(path, to string, opts MetaOptions) error {
+	keys := g.nodes.maps.keys()
+	vecs := make([][]float64, len(keys))
+	for i, key := range keys {
+		node, err := g.nodes.getNodeByKey(key)
+		if err != nil {
+			return err
+		}
+		if vecs[i], err = g.vector(node); err != nil {
+			return err
+		}
+	}
+	return buildDatabase(path, to, g.tree, g.dim, g.K, opts, keys, vecs)
+}
+
+func buildDatabase(path, to string, tree, dim, K int, opts MetaOptions, keys []int, vecs [][]float64) error {
+	if opts.Encoding == INT8 && len(opts.Min) == 0 && len(opts.Max) == 0 {
+		opts.Min, opts.Max = vectorRange(vecs, dim)
+	}
+	err := CreateMetaWithOptions(path, to, tree, dim, K, opts)
+	if err != nil {
+		return err
+	}
+
+	gannoy, err := NewGannoyIndex(filepath.Join(path, to+".meta"), Angular{}, RandRandom{})
+	if err != nil {
+		return err
+	}
 	return gannoy.AddItems(keys, vecs)
 }
+
+// vectorRange returns min and max of each dimension.
+func vectorRange(vecs [][]float64, dim int) ([]float64, []float64) {
+	min := make([]float64, dim)
+	max := make([]float64, dim)
+	first := true
+	for _, vec := range vecs {
+		if len(vec) != dim {
+			continue
+		}
+		for i, x := range vec {
+			if first || x < min[i] {
+				min[i] = x
+			}
+			if first || x > max[i] {
+				max[i] = x
+			}
+		}
+		first = false
+	}
+	return min, max
+}
@@ -0,0 +1,19 @@
+package gannoy
+
+import "testing"
+
+func TestVectorRange(t *testing.T) {
+	vecs := [][]float64{
+		{1.0, -1.0},
+		nil,
+		{-2.0, 3.0},
+		{0.5, 0.5},
+	}
+	min, max := vectorRange(vecs, 2)
+	if min[0] != -2.0 || min[1] != -1.0 {
+		t.Errorf("vectorRange should return min [-2 -1], but %v", min)
+	}
+	if max[0] != 1.0 || max[1] != 3.0 {
+		t.Errorf("vectorRange should return max [1 3], but %v", max)
+	}
+}
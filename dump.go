@@ -0,0 +1,135 @@
+package gannoy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+type DumpOptions struct {
+	Tree       int  // index of the tree, or -1 for all trees
+	Depth      int  // max depth to dump, or -1 for unlimited
+	OmitVector bool // do not include vectors
+}
+
+type DumpNode struct {
+	Id           int        `json:"id"`
+	Key          int        `json:"key"`
+	Type         string     `json:"type"`
+	NDescendants int        `json:"n_descendants"`
+	V            []float64  `json:"v,omitempty"`
+	Children     []DumpNode `json:"children,omitempty"`
+	Truncated    bool       `json:"truncated,omitempty"`
+}
+
+type DumpTree struct {
+	Index int       `json:"tree"`
+	Root  *DumpNode `json:"root"`
+}
+
+// Dump returns the split structure of trees selected by options.
+func (g GannoyIndex) Dump(opts DumpOptions) ([]DumpTree, error) {
+	roots := g.meta.roots()
+	if opts.Tree >= len(roots) {
+		return nil, fmt.Errorf("Tree index out of range. expect less than %d, but %d.", len(roots), opts.Tree)
+	}
+
+	trees := []DumpTree{}
+	for index, root := range roots {
+		if opts.Tree >= 0 && opts.Tree != index {
+			continue
+		}
+		tree := DumpTree{Index: index}
+		if root != -1 {
+			node, err := g.dumpNode(root, 0, opts)
+			if err != nil {
+				return trees, err
+			}
+			tree.Root = &node
+		}
+		trees = append(trees, tree)
+	}
+	return trees, nil
+}
+
+func (g GannoyIndex) dumpNode(id, depth int, opts DumpOptions) (DumpNode, error) {
+	node, err := g.nodes.getNode(id)
+	if err != nil {
+		return DumpNode{}, err
+	}
+	d := DumpNode{
+		Id:           id,
+		Key:          node.key,
+		NDescendants: node.nDescendants,
+	}
+	if !opts.OmitVector {
+		d.V = node.v
+	}
+	switch {
+	case node.isLeaf():
+		d.Type = "leaf"
+		return d, nil
+	case node.isBucket():
+		d.Type = "bucket"
+	default:
+		d.Type = "split"
+	}
+	if opts.Depth >= 0 && depth >= opts.Depth {
+		d.Truncated = true
+		return d, nil
+	}
+	d.Children = make([]DumpNode, len(node.children))
+	for i, child := range node.children {
+		d.Children[i], err = g.dumpNode(child, depth+1, opts)
+		if err != nil {
+			return d, err
+		}
+	}
+	return d, nil
+}
+
+// DumpJSON writes trees as JSON.
+func (g GannoyIndex) DumpJSON(w io.Writer, opts DumpOptions) error {
+	trees, err := g.Dump(opts)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(trees)
+}
+
+// DumpDot writes trees as Graphviz DOT, one digraph for each tree.
+func (g GannoyIndex) DumpDot(w io.Writer, opts DumpOptions) error {
+	trees, err := g.Dump(opts)
+	if err != nil {
+		return err
+	}
+	for _, tree := range trees {
+		fmt.Fprintf(w, "digraph tree%d {\n", tree.Index)
+		fmt.Fprintf(w, "  node [shape=box];\n")
+		if tree.Root != nil {
+			writeDotNode(w, *tree.Root)
+		}
+		fmt.Fprintf(w, "}\n")
+	}
+	return nil
+}
+
+func writeDotNode(w io.Writer, node DumpNode) {
+	label := fmt.Sprintf("%d %s\\nnDescendants: %d", node.Id, node.Type, node.NDescendants)
+	if node.Type == "leaf" {
+		label = fmt.Sprintf("%d leaf\\nkey: %d", node.Id, node.Key)
+	}
+	if len(node.V) > 0 {
+		label += fmt.Sprintf("\\nv: %v", node.V)
+	}
+	if node.Truncated {
+		label += "\\n..."
+	}
+	fmt.Fprintf(w, "  n%d [label=\"%s\"];\n", node.Id, label)
+	for _, child := range node.Children {
+		fmt.Fprintf(w, "  n%d -> n%d;\n", node.Id, child.Id)
+		writeDotNode(w, child)
+	}
+}
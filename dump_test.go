@@ -0,0 +1,95 @@
+package gannoy
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestGannoyIndexDump(t *testing.T) {
+	tree := 2
+	name := "test_gannoy_index_dump"
+	CreateMeta(".", name, tree, 3, 4)
+	defer os.Remove(name + ".meta")
+
+	treeFile := name + ".tree"
+	defer os.Remove(treeFile)
+	defer os.Remove(leavesPath(treeFile))
+	gannoy, _ := NewGannoyIndex(name+".meta", Angular{}, &TestLoopRandom{max: 1})
+
+	items := [][]float64{
+		{1.1, 1.2, 1.3},
+		{-1.1, -1.2, -1.3},
+		{1.1, 1.2, 1.3},
+		{-1.1, -1.2, -1.3},
+		{-1.1, -1.2, -1.3},
+	}
+	for i, item := range items {
+		gannoy.AddItem(i*10, item)
+	}
+
+	trees, err := gannoy.Dump(DumpOptions{Tree: -1, Depth: -1})
+	if err != nil {
+		t.Errorf("GannoyIndex Dump should not return error.")
+	}
+	if len(trees) != tree {
+		t.Errorf("GannoyIndex Dump should return %d trees, but %d", tree, len(trees))
+	}
+
+	// Single tree with depth
+	trees, _ = gannoy.Dump(DumpOptions{Tree: 1, Depth: 0, OmitVector: true})
+	if len(trees) != 1 || trees[0].Index != 1 {
+		t.Errorf("GannoyIndex Dump should return specified tree.")
+	}
+	root := trees[0].Root
+	if !root.Truncated || len(root.Children) != 0 {
+		t.Errorf("GannoyIndex Dump should truncate tree at specified depth.")
+	}
+	if len(root.V) != 0 {
+		t.Errorf("GannoyIndex Dump should omit vector.")
+	}
+
+	// Out of range
+	_, err = gannoy.Dump(DumpOptions{Tree: tree, Depth: -1})
+	if err == nil {
+		t.Errorf("GannoyIndex Dump with out of range tree should return error.")
+	}
+}
+
+func TestGannoyIndexDumpFormat(t *testing.T) {
+	name := "test_gannoy_index_dump_format"
+	CreateMeta(".", name, 1, 3, 4)
+	defer os.Remove(name + ".meta")
+
+	treeFile := name + ".tree"
+	defer os.Remove(treeFile)
+	defer os.Remove(leavesPath(treeFile))
+	gannoy, _ := NewGannoyIndex(name+".meta", Angular{}, &TestLoopRandom{max: 1})
+	gannoy.AddItem(10, []float64{1.1, 1.2, 1.3})
+	gannoy.AddItem(20, []float64{-1.1, -1.2, -1.3})
+
+	buf := &bytes.Buffer{}
+	err := gannoy.DumpJSON(buf, DumpOptions{Tree: -1, Depth: -1})
+	if err != nil {
+		t.Errorf("GannoyIndex DumpJSON should not return error.")
+	}
+	trees := []DumpTree{}
+	if err := json.Unmarshal(buf.Bytes(), &trees); err != nil {
+		t.Errorf("GannoyIndex DumpJSON should write valid JSON, but %v", err)
+	}
+	if len(trees) != 1 || len(trees[0].Root.Children) != 2 {
+		t.Errorf("GannoyIndex DumpJSON should contain root and children.")
+	}
+
+	buf = &bytes.Buffer{}
+	err = gannoy.DumpDot(buf, DumpOptions{Tree: -1, Depth: -1})
+	if err != nil {
+		t.Errorf("GannoyIndex DumpDot should not return error.")
+	}
+	dot := buf.String()
+	if !strings.HasPrefix(dot, "digraph tree0 {") || strings.Count(dot, "->") != 2 {
+		t.Errorf("GannoyIndex DumpDot should write digraph with edges, but %s", dot)
+	}
+}
@@ -0,0 +1,159 @@
+package gannoy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Durability policies, which control when written files are synced to disk.
+const (
+	DURABILITY_NONE     int = iota // leave syncing to OS
+	DURABILITY_WRITE               // sync after each write
+	DURABILITY_INTERVAL            // sync written files periodically
+)
+
+// Default interval of DURABILITY_INTERVAL.
+const defaultSyncInterval = time.Second
+
+// Durability is the policy of syncing tree, meta, change log and write-ahead log files.
+type Durability struct {
+	Policy   int           // DURABILITY_NONE, DURABILITY_WRITE or DURABILITY_INTERVAL
+	Interval time.Duration // interval of DURABILITY_INTERVAL (default: 1s)
+}
+
+func (d Durability) validate() (Durability, error) {
+	switch d.Policy {
+	case DURABILITY_NONE, DURABILITY_WRITE:
+		d.Interval = 0
+	case DURABILITY_INTERVAL:
+		if d.Interval < 0 {
+			return d, fmt.Errorf("Invalid sync interval: %v.", d.Interval)
+		}
+		if d.Interval == 0 {
+			d.Interval = defaultSyncInterval
+		}
+	default:
+		return d, fmt.Errorf("Unknown durability: %d.", d.Policy)
+	}
+	return d, nil
+}
+
+func durabilityName(policy int) string {
+	switch policy {
+	case DURABILITY_NONE:
+		return "none"
+	case DURABILITY_WRITE:
+		return "per-write"
+	case DURABILITY_INTERVAL:
+		return "interval"
+	default:
+		return "unknown"
+	}
+}
+
+// DurabilityFromName returns durability policy from name such as "per-write".
+func DurabilityFromName(name string) (int, error) {
+	switch name {
+	case "none":
+		return DURABILITY_NONE, nil
+	case "per-write":
+		return DURABILITY_WRITE, nil
+	case "interval":
+		return DURABILITY_INTERVAL, nil
+	default:
+		return -1, fmt.Errorf("Unknown durability: %s.", name)
+	}
+}
+
+// syncer is implemented by storages which can sync written files.
+type syncer interface {
+	sync() error
+}
+
+// syncState tracks writes not synced yet for DURABILITY_INTERVAL.
+type syncState struct {
+	mu    sync.Mutex
+	dirty bool
+	stop  chan struct{} // closed to stop syncer, nil if it is not running
+	done  chan struct{} // closed when syncer returns
+}
+
+// written syncs files after writes are applied or queued, according to durability.
+func (g *GannoyIndex) written() error {
+	switch g.durability.Policy {
+	case DURABILITY_WRITE:
+		return g.sync()
+	case DURABILITY_INTERVAL:
+		g.syncState.mu.Lock()
+		g.syncState.dirty = true
+		g.syncState.mu.Unlock()
+	}
+	return nil
+}
+
+// syncer syncs files periodically if they are written, until stop is closed.
+func (g *GannoyIndex) syncer(stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(g.durability.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		g.syncState.mu.Lock()
+		dirty := g.syncState.dirty
+		g.syncState.dirty = false
+		g.syncState.mu.Unlock()
+		if dirty {
+			g.sync()
+		}
+	}
+}
+
+// stopSyncer stops syncer and waits for it, and then syncs files written since the last sync.
+func (g *GannoyIndex) stopSyncer() error {
+	g.syncState.mu.Lock()
+	stop, done := g.syncState.stop, g.syncState.done
+	g.syncState.stop = nil
+	g.syncState.mu.Unlock()
+	if stop == nil {
+		return nil
+	}
+	close(stop)
+	<-done
+
+	g.syncState.mu.Lock()
+	dirty := g.syncState.dirty
+	g.syncState.dirty = false
+	g.syncState.mu.Unlock()
+	if dirty {
+		return g.sync()
+	}
+	return nil
+}
+
+// sync flushes written files of the database to disk.
+func (g *GannoyIndex) sync() error {
+	if s, ok := g.nodes.backend().(syncer); ok {
+		if err := s.sync(); err != nil {
+			return err
+		}
+	}
+	if err := g.meta.sync(); err != nil {
+		return err
+	}
+	if g.changes != nil {
+		if err := g.changes.sync(); err != nil {
+			return err
+		}
+	}
+	if g.queue != nil {
+		if err := g.queue.log.sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
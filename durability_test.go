@@ -0,0 +1,111 @@
+package gannoy
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDurabilityFromName(t *testing.T) {
+	for _, policy := range []int{DURABILITY_NONE, DURABILITY_WRITE, DURABILITY_INTERVAL} {
+		p, err := DurabilityFromName(durabilityName(policy))
+		if err != nil || p != policy {
+			t.Errorf("DurabilityFromName should return %d, but %d, %v", policy, p, err)
+		}
+	}
+	if _, err := DurabilityFromName("always"); err == nil {
+		t.Errorf("DurabilityFromName of unknown name should return error.")
+	}
+}
+
+func TestLoadMetaDurability(t *testing.T) {
+	file := "test_load_meta_durability"
+	durability := Durability{Policy: DURABILITY_INTERVAL, Interval: 1500 * time.Millisecond}
+	CreateMetaWithOptions(".", file, 2, 3, 4, MetaOptions{Durability: durability})
+	defer os.Remove(file + ".meta")
+
+	meta, _ := loadMeta(file + ".meta")
+	if meta.durability() != durability {
+		t.Errorf("Meta file should have durability %v, but %v.", durability, meta.durability())
+	}
+
+	// Interval is filled by default.
+	file = "test_load_meta_durability_default"
+	CreateMetaWithOptions(".", file, 2, 3, 4, MetaOptions{Durability: Durability{Policy: DURABILITY_INTERVAL}})
+	defer os.Remove(file + ".meta")
+	meta, _ = loadMeta(file + ".meta")
+	if meta.durability().Interval != defaultSyncInterval {
+		t.Errorf("Meta file should have default interval, but %v.", meta.durability())
+	}
+
+	if err := CreateMetaWithOptions(".", "test_unknown_durability", 2, 3, 4, MetaOptions{Durability: Durability{Policy: 100}}); err == nil {
+		os.Remove("test_unknown_durability.meta")
+		t.Errorf("CreateMetaWithOptions with unknown durability should return error.")
+	}
+}
+
+func TestGannoyIndexDurability(t *testing.T) {
+	name := "test_gannoy_index_durability"
+	CreateMetaWithOptions(".", name, 2, 3, 4, MetaOptions{Durability: Durability{Policy: DURABILITY_WRITE}})
+	defer os.Remove(name + ".meta")
+	defer os.Remove(name + ".tree")
+	defer os.Remove(name + ".leaves")
+	defer os.Remove(name + ".changes")
+	defer os.Remove(name + ".wal")
+
+	index, _ := NewGannoyIndexWithOptions(name+".meta", Options{ChangeLog: true, WAL: true})
+	if err := index.AddItem(0, []float64{1.1, 1.2, 1.3}); err != nil {
+		t.Errorf("AddItem with per-write durability should not return error, but %v", err)
+	}
+	id, err := index.AddItemAsync(1, []float64{-1.1, -1.2, -1.3})
+	if err != nil {
+		t.Errorf("AddItemAsync with per-write durability should not return error, but %v", err)
+	}
+	index.WaitWrite(id)
+	stats, _ := index.Stats()
+	if stats.Durability != "per-write" || stats.SyncInterval != "" {
+		t.Errorf("Stats should report durability of meta file, but %s %s", stats.Durability, stats.SyncInterval)
+	}
+
+	// Options overrides meta file.
+	index, _ = NewGannoyIndexWithOptions(name+".meta", Options{
+		Durability: &Durability{Policy: DURABILITY_INTERVAL, Interval: 10 * time.Millisecond},
+	})
+	index.AddItem(2, []float64{1.1, 1.2, 1.3})
+	stats, _ = index.Stats()
+	if stats.Durability != "interval" || stats.SyncInterval != "10ms" {
+		t.Errorf("Stats should report durability of options, but %s %s", stats.Durability, stats.SyncInterval)
+	}
+	dirty := true
+	for i := 0; i < 100 && dirty; i++ {
+		time.Sleep(10 * time.Millisecond)
+		index.syncState.mu.Lock()
+		dirty = index.syncState.dirty
+		index.syncState.mu.Unlock()
+	}
+	if dirty {
+		t.Errorf("Written files should be synced in interval.")
+	}
+
+	// Closing the index stops syncer.
+	done := index.syncState.done
+	index.AddItem(3, []float64{1.1, 1.2, 1.3})
+	if err := index.close(); err != nil {
+		t.Errorf("close should not return error, but %v", err)
+	}
+	select {
+	case <-done:
+	default:
+		t.Errorf("Syncer should be stopped by close.")
+	}
+	index.syncState.mu.Lock()
+	dirty = index.syncState.dirty
+	index.syncState.mu.Unlock()
+	if dirty {
+		t.Errorf("Written files should be synced by close.")
+	}
+
+	if _, err := NewGannoyIndexWithOptions(name+".meta", Options{Durability: &Durability{Policy: 100}}); err == nil {
+		t.Errorf("NewGannoyIndexWithOptions with unknown durability should return error.")
+	}
+}
@@ -0,0 +1,103 @@
+package gannoy
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+type EvalOptions struct {
+	Samples  int   // number of query keys
+	N        int   // number of neighbors (k of recall@k)
+	SearchKs []int // search_k values to sweep
+}
+
+type EvalResult struct {
+	SearchK int           `json:"search_k"`
+	Recall  float64       `json:"recall"`
+	P50     time.Duration `json:"p50"`
+	P90     time.Duration `json:"p90"`
+	P99     time.Duration `json:"p99"`
+	Max     time.Duration `json:"max"`
+}
+
+// Evaluate samples query keys and compares approximate results of GetAllNns
+// with exact results for each search_k.
+func (g *GannoyIndex) Evaluate(opts EvalOptions) ([]EvalResult, error) {
+	keys := g.nodes.maps.keys()
+	if len(keys) == 0 {
+		return []EvalResult{}, fmt.Errorf("Database is empty.")
+	}
+	samples := opts.Samples
+	if samples <= 0 || samples > len(keys) {
+		samples = len(keys)
+	}
+	perm := rand.Perm(len(keys))[:samples]
+
+	queries := make([][]float64, samples)
+	truths := make([]map[int]bool, samples)
+	for i, p := range perm {
+		node, err := g.nodes.getNodeByKey(keys[p])
+		if err != nil {
+			return []EvalResult{}, err
+		}
+		// Queries and truths use full-precision vectors rather than decoded codes.
+		v, err := g.vector(node)
+		if err != nil {
+			return []EvalResult{}, err
+		}
+		queries[i] = v
+		exact, err := g.GetAllNnsExact(v, opts.N)
+		if err != nil {
+			return []EvalResult{}, err
+		}
+		truths[i] = map[int]bool{}
+		for _, key := range exact {
+			truths[i][key] = true
+		}
+	}
+
+	results := make([]EvalResult, len(opts.SearchKs))
+	for i, searchK := range opts.SearchKs {
+		var found, total int
+		latencies := make([]time.Duration, samples)
+		for j, v := range queries {
+			start := time.Now()
+			nns, err := g.GetAllNns(v, opts.N, searchK)
+			latencies[j] = time.Since(start)
+			if err != nil {
+				return results, err
+			}
+			for _, key := range nns {
+				if truths[j][key] {
+					found++
+				}
+			}
+			total += len(truths[j])
+		}
+		sort.Slice(latencies, func(a, b int) bool { return latencies[a] < latencies[b] })
+		results[i] = EvalResult{
+			SearchK: searchK,
+			P50:     percentile(latencies, 0.50),
+			P90:     percentile(latencies, 0.90),
+			P99:     percentile(latencies, 0.99),
+			Max:     latencies[len(latencies)-1],
+		}
+		if total > 0 {
+			results[i].Recall = float64(found) / float64(total)
+		}
+	}
+	return results, nil
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	index := int(p*float64(len(sorted))+0.5) - 1
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(sorted) {
+		index = len(sorted) - 1
+	}
+	return sorted[index]
+}
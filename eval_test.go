@@ -0,0 +1,57 @@
+package gannoy
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestGannoyIndexEvaluate(t *testing.T) {
+	name := "test_gannoy_index_evaluate"
+	CreateMeta(".", name, 2, 3, 4)
+	defer os.Remove(name + ".meta")
+
+	treeFile := name + ".tree"
+	defer os.Remove(treeFile)
+	defer os.Remove(leavesPath(treeFile))
+	gannoy, _ := NewGannoyIndex(name+".meta", Angular{}, RandRandom{})
+
+	_, err := gannoy.Evaluate(EvalOptions{Samples: 1, N: 1, SearchKs: []int{1}})
+	if err == nil {
+		t.Errorf("GannoyIndex Evaluate with empty database should return error.")
+	}
+
+	for i := 0; i < 20; i++ {
+		gannoy.AddItem(i, []float64{float64(i%3) + 0.1, float64(i%5) + 0.1, float64(i%7) + 0.1})
+	}
+
+	results, err := gannoy.Evaluate(EvalOptions{Samples: 5, N: 3, SearchKs: []int{1, 100}})
+	if err != nil {
+		t.Errorf("GannoyIndex Evaluate should not return error, but %v", err)
+	}
+	if len(results) != 2 {
+		t.Errorf("GannoyIndex Evaluate should return result for each search_k, but %d", len(results))
+	}
+	for _, r := range results {
+		if r.Recall < 0 || r.Recall > 1 {
+			t.Errorf("Recall should be between 0 and 1, but %f", r.Recall)
+		}
+		if r.P50 > r.P99 || r.P99 > r.Max {
+			t.Errorf("Latency percentiles should be ordered, but %v", r)
+		}
+	}
+	// search_k which covers all items should find exact result.
+	if results[1].Recall != 1.0 {
+		t.Errorf("Recall with large search_k should be 1.0, but %f", results[1].Recall)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	latencies := []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	if p := percentile(latencies, 0.5); p != 5 {
+		t.Errorf("percentile 50 should be 5, but %d", p)
+	}
+	if p := percentile(latencies, 0.99); p != 10 {
+		t.Errorf("percentile 99 should be 10, but %d", p)
+	}
+}
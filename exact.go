@@ -0,0 +1,119 @@
+package gannoy
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Leaves fewer than this are scanned in a single goroutine.
+const exactChunkSize = 1024
+
+func (g *GannoyIndex) GetNnsByKeyExact(key, n int) ([]int, error) {
+	m, err := g.nodes.getNodeByKey(key)
+	if err != nil || !m.isLeaf() {
+		return []int{}, fmt.Errorf("Not found")
+	}
+	v, err := g.vector(m)
+	if err != nil {
+		return []int{}, err
+	}
+	return g.GetAllNnsExact(v, n)
+}
+
+// leafScanner is implemented by storages which keep leaf vectors densely
+// apart from tree structure, so that they can be scanned sequentially.
+type leafScanner interface {
+	leafSlots() (int, bool)
+	scanLeaves(start, end int, f func(key int, v []float64)) error
+}
+
+// GetAllNnsExact returns the exact nearest neighbors of v
+// by scanning all live leaves across workers.
+func (g *GannoyIndex) GetAllNnsExact(v []float64, n int) ([]int, error) {
+	if scanner, ok := g.nodes.backend().(leafScanner); ok && !g.quantized() {
+		if slots, ok := scanner.leafSlots(); ok {
+			return g.scanNnsExact(scanner, slots, v, n)
+		}
+	}
+
+	ids := g.nodes.maps.ids()
+	nnsDist := make([]sorter, len(ids))
+
+	worker := 1
+	if len(ids) > exactChunkSize {
+		worker = g.numWorker
+	}
+	chunk := (len(ids) + worker - 1) / worker
+
+	var wg sync.WaitGroup
+	errs := make([]error, worker)
+	for w := 0; w < worker; w++ {
+		start := w * chunk
+		end := start + chunk
+		if end > len(ids) {
+			end = len(ids)
+		}
+		wg.Add(1)
+		go func(w, start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				node, err := g.nodes.getNode(ids[i])
+				if err != nil {
+					errs[w] = err
+					return
+				}
+				x, err := g.vector(node)
+				if err != nil {
+					errs[w] = err
+					return
+				}
+				nnsDist[i] = sorter{value: g.distance.distance(v, x), id: node.key}
+			}
+		}(w, start, end)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return []int{}, err
+		}
+	}
+	return nearest(nnsDist, n), nil
+}
+
+// scanNnsExact reads leaves file sequentially in chunks across workers.
+func (g *GannoyIndex) scanNnsExact(scanner leafScanner, slots int, v []float64, n int) ([]int, error) {
+	worker := 1
+	if slots > exactChunkSize {
+		worker = g.numWorker
+	}
+	chunk := (slots + worker - 1) / worker
+
+	var wg sync.WaitGroup
+	errs := make([]error, worker)
+	dists := make([][]sorter, worker)
+	for w := 0; w < worker; w++ {
+		start := w * chunk
+		end := start + chunk
+		if end > slots {
+			end = slots
+		}
+		wg.Add(1)
+		go func(w, start, end int) {
+			defer wg.Done()
+			errs[w] = scanner.scanLeaves(start, end, func(key int, x []float64) {
+				dists[w] = append(dists[w], sorter{value: g.distance.distance(v, x), id: key})
+			})
+		}(w, start, end)
+	}
+	wg.Wait()
+
+	nnsDist := []sorter{}
+	for w, err := range errs {
+		if err != nil {
+			return []int{}, err
+		}
+		nnsDist = append(nnsDist, dists[w]...)
+	}
+	return nearest(nnsDist, n), nil
+}
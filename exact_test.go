@@ -0,0 +1,90 @@
+package gannoy
+
+import (
+	"os"
+	"testing"
+)
+
+func TestGannoyIndexGetAllNnsExact(t *testing.T) {
+	name := "test_gannoy_index_get_all_nns_exact"
+	CreateMeta(".", name, 2, 3, 4)
+	defer os.Remove(name + ".meta")
+
+	treeFile := name + ".tree"
+	defer os.Remove(treeFile)
+	defer os.Remove(leavesPath(treeFile))
+	gannoy, _ := NewGannoyIndex(name+".meta", Angular{}, &TestLoopRandom{max: 1})
+
+	items := [][]float64{
+		{1.0, 0.0, 0.0},
+		{0.9, 0.1, 0.0},
+		{0.0, 1.0, 0.0},
+		{0.0, 0.0, 1.0},
+		{-1.0, 0.0, 0.0},
+	}
+	for i, item := range items {
+		gannoy.AddItem(i*10, item)
+	}
+
+	nns, err := gannoy.GetAllNnsExact([]float64{1.0, 0.0, 0.0}, 3)
+	if err != nil {
+		t.Errorf("GannoyIndex GetAllNnsExact should not return error.")
+	}
+	expect := []int{0, 10}
+	if len(nns) != 3 {
+		t.Errorf("GannoyIndex GetAllNnsExact should return %d items, but %d", 3, len(nns))
+	}
+	for i, key := range expect {
+		if nns[i] != key {
+			t.Errorf("GannoyIndex GetAllNnsExact should return %v in order, but %v", expect, nns)
+			break
+		}
+	}
+	if nns[2] != 20 && nns[2] != 30 {
+		t.Errorf("GannoyIndex GetAllNnsExact should not return far item, but %d", nns[2])
+	}
+
+	// removed item is not returned.
+	gannoy.RemoveItem(10)
+	nns, _ = gannoy.GetAllNnsExact([]float64{1.0, 0.0, 0.0}, 5)
+	if len(nns) != 4 {
+		t.Errorf("GannoyIndex GetAllNnsExact should return only live items, but %v", nns)
+	}
+}
+
+func TestGannoyIndexGetNnsByKeyExact(t *testing.T) {
+	name := "test_gannoy_index_get_nns_by_key_exact"
+	CreateMeta(".", name, 1, 3, 4)
+	defer os.Remove(name + ".meta")
+
+	treeFile := name + ".tree"
+	defer os.Remove(treeFile)
+	defer os.Remove(leavesPath(treeFile))
+	gannoy, _ := NewGannoyIndex(name+".meta", Angular{}, RandRandom{})
+
+	count := exactChunkSize + 10
+	for i := 0; i < count; i++ {
+		gannoy.AddItem(i, []float64{float64(i%3) + 0.1, float64(i%5) + 0.1, float64(i%7) + 0.1})
+	}
+
+	// Not found key
+	nns, err := gannoy.GetNnsByKeyExact(count, 3)
+	if len(nns) != 0 || err == nil {
+		t.Errorf("GannoyIndex GetNnsByKeyExact should return error if key is not found.")
+	}
+
+	// Exist key (scanned by multiple workers)
+	nns, err = gannoy.GetNnsByKeyExact(0, 3)
+	if err != nil {
+		t.Errorf("GannoyIndex GetNnsByKeyExact should not return error if key exist.")
+	}
+	if len(nns) != 3 {
+		t.Errorf("GannoyIndex GetNnsByKeyExact should return specified size list, but %v", nns)
+	}
+	for _, key := range nns {
+		if key%105 > 2 { // (0.1, 0.1, 0.1), (1.1, 1.1, 1.1) or (2.1, 2.1, 2.1)
+			t.Errorf("GannoyIndex GetNnsByKeyExact should return same direction items, but %v", nns)
+			break
+		}
+	}
+}
@@ -3,11 +3,15 @@ package gannoy
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"hash/crc32"
 	"math"
 	"os"
 	"syscall"
 )
 
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
 type File struct {
 	tree       int
 	dim        int
@@ -19,17 +23,70 @@ type File struct {
 	locker     Locker
 	nodeSize   int64
 	offsetOfV  int64
+	leafCodec  codec
+	splitCodec codec
+	vectors    *vectors
+	header     header
+	order      binary.ByteOrder
+	checksum   bool    // each node ends with CRC32 of it
+	leaves     *leaves // leaves are kept in leaves file instead of tree file if not nil
+	readOnly   bool    // opened read-only, neither written nor locked
+}
+
+func newFile(filename string, tree, dim, K int) (*File, error) {
+	return newFileWithCodec(filename, tree, dim, K, float64Codec{dim: dim, order: binary.BigEndian}, newHeader(treeMagic, BIG_ENDIAN, ANGULAR))
+}
+
+func newFileWithCodec(filename string, tree, dim, K int, codec codec, h header) (*File, error) {
+	return newFileWithCodecs(filename, tree, dim, K, codec, codec, nil, h, false)
 }
 
-func newFile(filename string, tree, dim, K int) *File {
-	_, err := os.Stat(filename)
+// newFileWithCodecs encodes leaves and split nodes by each codec.
+// If vectors is given, full-precision vectors of leaves are also kept in it.
+// Header h is written if the file is new, otherwise the header of the file must match it.
+// If readOnly, the file must exist and it is never written nor locked.
+func newFileWithCodecs(filename string, tree, dim, K int, leafCodec, splitCodec codec, vectors *vectors, h header, readOnly bool) (*File, error) {
+	var file, appendFile *os.File
+	locker := newLocker()
+	if readOnly {
+		var err error
+		if file, err = os.Open(filename); err != nil {
+			return nil, err
+		}
+		locker = noLocker{}
+	} else {
+		_, err := os.Stat(filename)
+		if err != nil {
+			f, _ := os.Create(filename)
+			f.Close()
+		}
+
+		file, _ = os.OpenFile(filename, os.O_RDWR, 0)
+		appendFile, _ = os.OpenFile(filename, os.O_RDWR|os.O_APPEND, 0)
+	}
+
+	h, err := initHeader(file, appendFile, h)
 	if err != nil {
-		f, _ := os.Create(filename)
-		f.Close()
+		file.Close()
+		appendFile.Close()
+		return nil, err
 	}
 
-	file, _ := os.OpenFile(filename, os.O_RDWR, 0)
-	appendFile, _ := os.OpenFile(filename, os.O_RDWR|os.O_APPEND, 0)
+	// Bucket nodes use children and v area for K children.
+	vSize := 4 * (K - 2)
+	if splitCodec.size() > vSize {
+		vSize = splitCodec.size()
+	}
+	var l *leaves
+	if h.version >= 2 {
+		if l, err = newLeaves(leavesPath(filename), tree, leafCodec, h, readOnly); err != nil {
+			file.Close()
+			appendFile.Close()
+			return nil, err
+		}
+	} else if leafCodec.size() > vSize {
+		vSize = leafCodec.size()
+	}
 
 	f := &File{
 		tree:       tree,
@@ -38,25 +95,72 @@ func newFile(filename string, tree, dim, K int) *File {
 		file:       file,
 		filename:   filename,
 		appendFile: appendFile,
-		createChan: make(chan createArgs, 1),
-		locker:     newLocker(),
+		locker:     locker,
+		leafCodec:  leafCodec,
+		splitCodec: splitCodec,
+		vectors:    vectors,
+		header:     h,
+		order:      h.order(),
+		checksum:   !h.legacy(),
+		leaves:     l,
+		readOnly:   readOnly,
 		nodeSize: int64(1 + // free
 			4 + // nDescendants
 			4 + // key
 			4*tree + // parents
 			4*2 + // children
-			8*dim), // v
+			vSize), // v
 		offsetOfV: int64(1 + // free
 			4 + // nDescendants
 			4 + // key
 			4*tree + // parents
 			4*2), // children
 	}
-	go f.creator()
-	return f
+	if f.checksum {
+		f.nodeSize += 4
+	}
+	if !readOnly {
+		f.createChan = make(chan createArgs, 1)
+		go f.creator()
+	}
+	return f, nil
+}
+
+// initHeader writes h into new file, or reads header of existing file.
+// appendFile is nil if the file is opened read-only.
+func initHeader(file, appendFile *os.File, h header) (header, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return h, err
+	}
+	if stat.Size() == 0 {
+		if appendFile == nil {
+			return h, fmt.Errorf("Empty file: %s.", file.Name())
+		}
+		if !h.legacy() {
+			_, err = appendFile.Write(h.bytes())
+		}
+		return h, err
+	}
+	b := make([]byte, headerSize)
+	syscall.Pread(int(file.Fd()), b, 0)
+	found, err := parseHeader(h.magic, b)
+	if err != nil {
+		return found, err
+	}
+	if found.version != h.version {
+		return found, fmt.Errorf("Format version mismatch. expect %d, but %d.", h.version, found.version)
+	}
+	if found.endian != h.endian {
+		return found, fmt.Errorf("Endian mismatch. expect %s, but %s.", endianName(h.endian), endianName(found.endian))
+	}
+	return found, nil
 }
 
 func (f *File) Create(n Node) (int, error) {
+	if f.readOnly {
+		return -1, ErrReadOnly
+	}
 	args := createArgs{node: n, result: make(chan createResult)}
 	f.createChan <- args
 	result := <-args.result
@@ -64,12 +168,33 @@ func (f *File) Create(n Node) (int, error) {
 }
 
 func (f *File) create(n Node) (int, error) {
-	id := f.nodeCount()
+	if f.leaves != nil && n.isLeaf() {
+		id := leafId(f.leaves.allocate())
+		if err := f.leaves.write(leafSlot(id), n); err != nil {
+			return id, err
+		}
+		return id, f.writeVector(id, n)
+	}
+	id := f.treeNodes()
 	_, err := f.appendFile.Write(f.nodeToBytes(n))
-	return id, err
+	if err != nil {
+		return id, err
+	}
+	return id, f.writeVector(id, n)
+}
+
+// isLeafId returns whether the id refers a leaf in leaves file.
+func (f *File) isLeafId(id int) bool {
+	return f.leaves != nil && id >= 0 && id&leafIdBit != 0
 }
 
 func (f *File) Find(id int) (Node, error) {
+	if f.isLeafId(id) {
+		node, err := f.leaves.node(leafSlot(id))
+		node.id = id
+		node.storage = f
+		return node, err
+	}
 	node := Node{}
 	node.id = id
 	node.storage = f
@@ -85,41 +210,75 @@ func (f *File) Find(id int) (Node, error) {
 	if err != nil {
 		return node, err
 	}
+	return f.decode(node, b)
+}
 
+// decode verifies and decodes the node in tree file.
+func (f File) decode(node Node, b []byte) (Node, error) {
+	if err := f.verify(node.id, b); err != nil {
+		return node, err
+	}
+	return f.bytesToNode(node, b), nil
+}
+
+// verify checks CRC32 of the node.
+func (f File) verify(id int, b []byte) error {
+	if !f.checksum {
+		return nil
+	}
+	sum := f.order.Uint32(b[f.nodeSize-4:])
+	if crc32.Checksum(b[:f.nodeSize-4], crcTable) != sum {
+		return fmt.Errorf("Node %d is corrupted.", id)
+	}
+	return nil
+}
+
+func (f File) bytesToNode(node Node, b []byte) Node {
 	node.free = b[0] != 0
-	node.nDescendants = int(int32(binary.BigEndian.Uint32(b[1:5])))
-	node.key = int(int32(binary.BigEndian.Uint32(b[5:9])))
+	node.nDescendants = int(int32(f.order.Uint32(b[1:5])))
+	node.key = int(int32(f.order.Uint32(b[5:9])))
 
 	node.parents = make([]int, f.tree)
 	for i := 0; i < f.tree; i++ {
-		node.parents[i] = int(int32(binary.BigEndian.Uint32(b[9+i*4 : 9+i*4+4])))
+		node.parents[i] = int(int32(f.order.Uint32(b[9+i*4 : 9+i*4+4])))
 	}
 
 	if node.nDescendants == 1 {
 		// leaf node
 		node.children = []int{0, 0} // skip children
-		node.v = bytesToFloat64s(b[f.offsetOfV:])
+		node.v = f.leafCodec.decode(b[f.offsetOfV:])
 	} else if node.nDescendants <= f.K {
 		// bucket node
 		node.children = make([]int, node.nDescendants)
 		offsetOfChildren := int(f.offsetOfV - (4 * 2))
 		for i := 0; i < node.nDescendants; i++ {
-			node.children[i] = int(int32(binary.BigEndian.Uint32(b[offsetOfChildren+i*4 : offsetOfChildren+i*4+4])))
+			node.children[i] = int(int32(f.order.Uint32(b[offsetOfChildren+i*4 : offsetOfChildren+i*4+4])))
 		}
 	} else {
 		// other node
 		node.children = make([]int, 2)
 		offsetOfChildren := int(f.offsetOfV - (4 * 2))
 		for i := 0; i < 2; i++ {
-			node.children[i] = int(int32(binary.BigEndian.Uint32(b[offsetOfChildren+i*4 : offsetOfChildren+i*4+4])))
+			node.children[i] = int(int32(f.order.Uint32(b[offsetOfChildren+i*4 : offsetOfChildren+i*4+4])))
 		}
-		node.v = bytesToFloat64s(b[f.offsetOfV:])
+		node.v = f.splitCodec.decode(b[f.offsetOfV:])
 	}
-	return node, nil
+	return node
 }
 
 func (f *File) Update(n Node) error {
-	bytes := f.nodeToBytes(n)
+	if f.readOnly {
+		return ErrReadOnly
+	}
+	if f.isLeafId(n.id) != (f.leaves != nil && n.isLeaf()) {
+		return fmt.Errorf("Node %d can not be changed into other kind.", n.id)
+	}
+	if f.isLeafId(n.id) {
+		if err := f.leaves.write(leafSlot(n.id), n); err != nil {
+			return err
+		}
+		return f.writeVector(n.id, n)
+	}
 	offset := f.offset(n.id)
 	file, _ := os.OpenFile(f.filename, os.O_RDWR, 0)
 	defer file.Close()
@@ -130,18 +289,76 @@ func (f *File) Update(n Node) error {
 	}
 	defer f.locker.UnLock(file.Fd(), offset, f.nodeSize)
 
-	_, err = syscall.Pwrite(int(file.Fd()), bytes, offset)
-	return err
+	_, err = syscall.Pwrite(int(file.Fd()), f.nodeToBytes(n), offset)
+	if err != nil {
+		return err
+	}
+	return f.writeVector(n.id, n)
+}
+
+func (f *File) writeVector(id int, n Node) error {
+	if f.vectors == nil || n.free || !n.isLeaf() {
+		return nil
+	}
+	return f.vectors.write(leafSlot(id), n.v)
+}
+
+// findVector returns full-precision vector of the leaf if it is kept.
+func (f *File) findVector(id int) ([]float64, bool, error) {
+	if f.vectors == nil {
+		return nil, false, nil
+	}
+	v, err := f.vectors.read(leafSlot(id))
+	return v, true, err
+}
+
+// quantizeSplit returns the split plane as it is read from the file.
+func (f *File) quantizeSplit(v []float64) []float64 {
+	b := make([]byte, f.splitCodec.size())
+	f.splitCodec.encode(b, v)
+	return f.splitCodec.decode(b)
+}
+
+func (f *File) findCode(id int) (int, []byte, error) {
+	if f.isLeafId(id) {
+		return f.leaves.code(leafSlot(id))
+	}
+	offset := f.offset(id)
+	err := f.locker.ReadLock(f.file.Fd(), offset, f.nodeSize)
+	if err != nil {
+		return -1, nil, err
+	}
+	defer f.locker.UnLock(f.file.Fd(), offset, f.nodeSize)
+
+	b := make([]byte, f.nodeSize)
+	_, err = syscall.Pread(int(f.file.Fd()), b, offset)
+	if err != nil {
+		return -1, nil, err
+	}
+	if err := f.verify(id, b); err != nil {
+		return -1, nil, err
+	}
+	key := int(int32(f.order.Uint32(b[5:9])))
+	return key, b[f.offsetOfV : f.offsetOfV+int64(f.leafCodec.size())], nil
 }
 
 func (f *File) UpdateParent(id, rootIndex, parent int) error {
+	if f.readOnly {
+		return ErrReadOnly
+	}
+	if f.isLeafId(id) {
+		return f.leaves.updateParent(leafSlot(id), rootIndex, parent)
+	}
+	if f.checksum {
+		return f.updateParentWithChecksum(id, rootIndex, parent)
+	}
 	offset := f.offset(id) +
 		int64(1+ // free
 			4+ // nDescendants
 			4+ // key
 			4*rootIndex) // parents
 	buf := &bytes.Buffer{}
-	binary.Write(buf, binary.BigEndian, int32(parent))
+	binary.Write(buf, f.order, int32(parent))
 
 	file, _ := os.OpenFile(f.filename, os.O_RDWR, 0)
 	defer file.Close()
@@ -156,18 +373,62 @@ func (f *File) UpdateParent(id, rootIndex, parent int) error {
 	return err
 }
 
+// updateParentWithChecksum rewrites the whole node to update its CRC32.
+func (f *File) updateParentWithChecksum(id, rootIndex, parent int) error {
+	offset := f.offset(id)
+	file, _ := os.OpenFile(f.filename, os.O_RDWR, 0)
+	defer file.Close()
+
+	err := f.locker.WriteLock(file.Fd(), offset, f.nodeSize)
+	if err != nil {
+		return err
+	}
+	defer f.locker.UnLock(file.Fd(), offset, f.nodeSize)
+
+	b := make([]byte, f.nodeSize)
+	_, err = syscall.Pread(int(file.Fd()), b, offset)
+	if err != nil {
+		return err
+	}
+	if err := f.verify(id, b); err != nil {
+		return err
+	}
+	f.order.PutUint32(b[9+rootIndex*4:9+rootIndex*4+4], uint32(parent))
+	f.sum(b)
+	_, err = syscall.Pwrite(int(file.Fd()), b, offset)
+	return err
+}
+
 func (f *File) Delete(n Node) error {
 	n.free = true
 	return f.Update(n)
 }
 
 func (f *File) Iterate(c chan Node) {
-	count := f.nodeCount()
+	iterate(f, f.ids(), c)
+}
+
+// ids returns ids of nodes in tree file, followed by leaves in leaves file.
+func (f *File) ids() []int {
+	ids := make([]int, f.treeNodes())
+	for i := range ids {
+		ids[i] = i
+	}
+	if f.leaves != nil {
+		for slot := 0; slot < f.leaves.slots(); slot++ {
+			ids = append(ids, leafId(slot))
+		}
+	}
+	return ids
+}
+
+// iterate sends nodes of ids found in storage until error.
+func iterate(storage Storage, ids []int, c chan Node) {
 	// TODO: Use goroutine
-	for i := 0; i < count; i++ {
-		n, err := f.Find(i)
+	for _, id := range ids {
+		n, err := storage.Find(id)
 		if err != nil {
-			close(c)
+			break
 		}
 		c <- n
 	}
@@ -175,15 +436,36 @@ func (f *File) Iterate(c chan Node) {
 }
 
 func (f File) offset(id int) int64 {
-	return (int64(id) * f.nodeSize)
+	return f.header.size() + (int64(id) * f.nodeSize)
 }
 
+// nodeCount returns the number of nodes including leaves in leaves file.
 func (f File) nodeCount() int {
+	if f.leaves != nil {
+		return f.treeNodes() + f.leaves.slots()
+	}
+	return f.treeNodes()
+}
+
+// treeNodes returns the number of nodes in tree file.
+func (f File) treeNodes() int {
+	return int((f.treeSize() - f.header.size()) / f.nodeSize)
+}
+
+// treeSize returns size of tree file, excluding leaves and vector files.
+func (f File) treeSize() int64 {
 	stat, _ := f.file.Stat()
-	size := stat.Size()
-	return int(size / f.nodeSize)
+	return stat.Size()
 }
 
+// sum writes CRC32 into the end of the node.
+func (f File) sum(b []byte) {
+	if f.checksum {
+		f.order.PutUint32(b[f.nodeSize-4:], crc32.Checksum(b[:f.nodeSize-4], crcTable))
+	}
+}
+
+// nodeToBytes encodes the node in tree file.
 func (f File) nodeToBytes(node Node) []byte {
 	bytes := make([]byte, f.nodeSize)
 
@@ -194,18 +476,18 @@ func (f File) nodeToBytes(node Node) []byte {
 		bytes[0] = 0
 	}
 	// 4bytes nDescendants
-	binary.BigEndian.PutUint32(bytes[1:5], uint32(node.nDescendants))
+	f.order.PutUint32(bytes[1:5], uint32(node.nDescendants))
 	// 4bytes key
-	binary.BigEndian.PutUint32(bytes[5:9], uint32(node.key))
+	f.order.PutUint32(bytes[5:9], uint32(node.key))
 	// 4bytes parents
 	for i := 0; i < f.tree; i++ {
-		binary.BigEndian.PutUint32(bytes[9+i*4:9+i*4+4], uint32(node.parents[i]))
+		f.order.PutUint32(bytes[9+i*4:9+i*4+4], uint32(node.parents[i]))
 	}
 	if node.isBucket() {
 		// 4bytes children in K
 		offsetOfChildren := int(f.offsetOfV - (4 * 2))
 		for i, child := range node.children {
-			binary.BigEndian.PutUint32(bytes[offsetOfChildren+i*4:offsetOfChildren+i*4+4], uint32(child))
+			f.order.PutUint32(bytes[offsetOfChildren+i*4:offsetOfChildren+i*4+4], uint32(child))
 		}
 		// padding by zero (nothing to do)
 	} else {
@@ -213,13 +495,16 @@ func (f File) nodeToBytes(node Node) []byte {
 		offsetOfChildren := int(f.offsetOfV - (4 * 2))
 		// 4bytes 2 children
 		for i, child := range node.children {
-			binary.BigEndian.PutUint32(bytes[offsetOfChildren+i*4:offsetOfChildren+i*4+4], uint32(child))
+			f.order.PutUint32(bytes[offsetOfChildren+i*4:offsetOfChildren+i*4+4], uint32(child))
 		}
-		// 8bytes v in f
-		for i, v := range node.v {
-			binary.BigEndian.PutUint64(bytes[offsetOfV+i*8:offsetOfV+i*8+8], math.Float64bits(v))
+		// v encoded by codec
+		if node.isLeaf() {
+			f.leafCodec.encode(bytes[offsetOfV:], node.v)
+		} else {
+			f.splitCodec.encode(bytes[offsetOfV:], node.v)
 		}
 	}
+	f.sum(bytes)
 	return bytes
 }
 
@@ -243,16 +528,73 @@ func (f *File) creator() {
 	}
 }
 
+// Close stops the creator and closes tree, leaves and vector files.
+// It must not be called while the file is written.
+func (f *File) Close() error {
+	if f.createChan != nil {
+		close(f.createChan)
+	}
+	files := []*os.File{f.file, f.appendFile}
+	if f.leaves != nil {
+		files = append(files, f.leaves.file)
+	}
+	if f.vectors != nil {
+		files = append(files, f.vectors.file)
+	}
+	var err error
+	for _, file := range files {
+		if file == nil {
+			continue
+		}
+		if e := file.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// size returns size of tree and leaves files.
 func (f File) size() int64 {
-	info, _ := f.file.Stat()
-	return info.Size()
+	if f.leaves != nil {
+		return f.treeSize() + f.leaves.size()
+	}
+	return f.treeSize()
+}
+
+// sync flushes tree, leaves and vector files to disk.
+func (f *File) sync() error {
+	if err := f.file.Sync(); err != nil {
+		return err
+	}
+	if f.leaves != nil {
+		if err := f.leaves.file.Sync(); err != nil {
+			return err
+		}
+	}
+	if f.vectors != nil {
+		return f.vectors.file.Sync()
+	}
+	return nil
+}
+
+// leafSlots returns number of slots in leaves file, and whether leaves file is used.
+func (f *File) leafSlots() (int, bool) {
+	if f.leaves == nil {
+		return 0, false
+	}
+	return f.leaves.slots(), true
+}
+
+// scanLeaves reads live leaves between slots sequentially.
+func (f *File) scanLeaves(start, end int, fn func(key int, v []float64)) error {
+	return f.leaves.scan(start, end, fn)
 }
 
-func bytesToFloat64s(bytes []byte) []float64 {
+func bytesToFloat64s(order binary.ByteOrder, bytes []byte) []float64 {
 	size := len(bytes) / 8
 	floats := make([]float64, size)
 	for i := 0; i < size; i++ {
-		floats[i] = math.Float64frombits(binary.BigEndian.Uint64(bytes[0:8]))
+		floats[i] = math.Float64frombits(order.Uint64(bytes[0:8]))
 		bytes = bytes[8:]
 	}
 	return floats
@@ -1,6 +1,7 @@
 package gannoy
 
 import (
+	"encoding/binary"
 	"os"
 	"testing"
 )
@@ -8,7 +9,8 @@ import (
 func TestFileCreateAndFind(t *testing.T) {
 	name := "test_file_create_and_find.tree"
 	defer os.Remove(name)
-	file := newFile(name, 2, 3, 6)
+	defer os.Remove(leavesPath(name))
+	file, _ := newFile(name, 2, 3, 6)
 
 	nodes := []Node{
 		// Leaf node
@@ -37,10 +39,12 @@ func TestFileCreateAndFind(t *testing.T) {
 	}
 
 	// Create
+	// Leaves are kept in leaves file apart from ids of tree file.
+	ids := []int{leafId(0), 0, 1}
 	for i, node := range nodes {
 		id, err := file.Create(node)
-		if id != i {
-			t.Errorf("File create should return id: %d, but %d", i, id)
+		if id != ids[i] {
+			t.Errorf("File create should return id: %d, but %d", ids[i], id)
 		}
 		if err != nil {
 			t.Errorf("File create should not return error.")
@@ -48,7 +52,8 @@ func TestFileCreateAndFind(t *testing.T) {
 	}
 
 	// Find
-	for id, node := range nodes {
+	for i, node := range nodes {
+		id := ids[i]
 		found, err := file.Find(id)
 		if err != nil {
 			t.Errorf("File find should not return error.")
@@ -86,7 +91,8 @@ func TestFileCreateAndFind(t *testing.T) {
 func TestFileUpdate(t *testing.T) {
 	name := "test_file_update.tree"
 	defer os.Remove(name)
-	file := newFile(name, 2, 3, 4)
+	defer os.Remove(leavesPath(name))
+	file, _ := newFile(name, 2, 3, 4)
 
 	node := Node{
 		key:          10,
@@ -117,7 +123,8 @@ func TestFileUpdate(t *testing.T) {
 func TestUpdateParent(t *testing.T) {
 	name := "test_file_update_parent.tree"
 	defer os.Remove(name)
-	file := newFile(name, 2, 3, 4)
+	defer os.Remove(leavesPath(name))
+	file, _ := newFile(name, 2, 3, 4)
 
 	node := Node{
 		key:          10,
@@ -148,7 +155,8 @@ func TestUpdateParent(t *testing.T) {
 func TestFileIterate(t *testing.T) {
 	name := "test_file_iterate.tree"
 	defer os.Remove(name)
-	file := newFile(name, 2, 3, 4)
+	defer os.Remove(leavesPath(name))
+	file, _ := newFile(name, 2, 3, 4)
 
 	nodes := []Node{
 		// Leaf node
@@ -183,11 +191,62 @@ func TestFileIterate(t *testing.T) {
 	iterator := make(chan Node)
 	go file.Iterate(iterator)
 
+	// Nodes in tree file are followed by leaves.
+	keys := []int{20, 30, 10}
 	i := 0
 	for node := range iterator {
-		if nodes[i].key != node.key {
-			t.Errorf("File iterate should return node (key: %d), but %d", nodes[i].key, node.key)
+		if keys[i] != node.key {
+			t.Errorf("File iterate should return node (key: %d), but %d", keys[i], node.key)
 		}
 		i++
 	}
 }
+
+func TestFileChecksum(t *testing.T) {
+	name := "test_file_checksum.tree"
+	defer os.Remove(name)
+	defer os.Remove(leavesPath(name))
+	file, _ := newFile(name, 2, 3, 4)
+
+	id, _ := file.Create(Node{
+		key:          10,
+		nDescendants: 1,
+		parents:      []int{2, 3},
+		children:     []int{0, 0},
+		v:            []float64{1.1, 1.2, 1.3},
+	})
+	file.UpdateParent(id, 0, 5)
+	if _, err := file.Find(id); err != nil {
+		t.Errorf("File UpdateParent should keep checksum, but %v", err)
+	}
+
+	// corrupt key
+	f, _ := os.OpenFile(leavesPath(name), os.O_RDWR, 0)
+	f.WriteAt([]byte{1}, file.leaves.offset(leafSlot(id))+1)
+	f.Close()
+	if _, err := file.Find(id); err == nil {
+		t.Errorf("File Find of corrupted node should return error.")
+	}
+}
+
+func TestFileLegacy(t *testing.T) {
+	name := "test_file_legacy.tree"
+	defer os.Remove(name)
+	defer os.Remove(leavesPath(name))
+	file, _ := newFileWithCodec(name, 2, 3, 4, float64Codec{dim: 3, order: binary.BigEndian}, header{magic: treeMagic})
+	file.Create(Node{
+		key:          10,
+		nDescendants: 1,
+		parents:      []int{2, 3},
+		children:     []int{0, 0},
+		v:            []float64{1.1, 1.2, 1.3},
+	})
+	if size := file.size(); size != int64(1+4+4+4*2+4*2+8*3) {
+		t.Errorf("File without header should not have header and checksum, but size %d", size)
+	}
+
+	// Tree file without header is not opened as the current format.
+	if _, err := newFileWithCodec(name, 2, 3, 4, float64Codec{dim: 3, order: binary.BigEndian}, newHeader(treeMagic, BIG_ENDIAN, ANGULAR)); err == nil {
+		t.Errorf("File with format mismatch should return error.")
+	}
+}
@@ -10,8 +10,8 @@ type Free struct {
 	free []int
 }
 
-func newFree() Free {
-	return Free{
+func newFree() *Free {
+	return &Free{
 		mu:   sync.Mutex{},
 		free: []int{},
 	}
@@ -36,3 +36,31 @@ func (f *Free) pop() (int, error) {
 	f.free = newFree
 	return x, nil
 }
+
+// popIf pops the latest id which satisfies fn.
+func (f *Free) popIf(fn func(int) bool) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := len(f.free) - 1; i >= 0; i-- {
+		if x := f.free[i]; fn(x) {
+			f.free = append(f.free[:i], f.free[i+1:]...)
+			return x, nil
+		}
+	}
+	return -1, fmt.Errorf("empty")
+}
+
+func (f *Free) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.free)
+}
+
+func (f *Free) clear() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.free = []int{}
+}
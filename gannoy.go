@@ -2,6 +2,7 @@ package gannoy
 
 import (
 	"fmt"
+	"io"
 	"math"
 	"runtime"
 	"sort"
@@ -11,42 +12,80 @@ import (
 )
 
 type GannoyIndex struct {
-	meta      meta
-	tree      int
-	dim       int
-	distance  Distance
-	random    Random
-	nodes     Nodes
-	K         int
-	numWorker int
-	buildChan chan buildArgs
+	meta       meta
+	tree       int
+	dim        int
+	distance   Distance
+	random     Random
+	nodes      Nodes
+	K          int
+	Rerank     int // number of candidates re-ranked by full-precision vectors of quantized leaves
+	numWorker  int
+	readOnly   bool
+	changes    *changeLog  // nil if change log is disabled
+	queue      *writeQueue // nil if write-ahead log is disabled
+	buildChan  chan buildArgs
+	locks      *writeLocks
+	durability Durability
+	syncState  *syncState
 }
 
 func NewGannoyIndex(metaFile string, distance Distance, random Random) (GannoyIndex, error) {
+	return NewGannoyIndexWithStorage(metaFile, distance, random, FILE)
+}
 
-	meta, err := loadMeta(metaFile)
-	if err != nil {
-		return GannoyIndex{}, err
-	}
+func NewGannoyIndexWithStorage(metaFile string, distance Distance, random Random, storageType int) (GannoyIndex, error) {
+	return NewGannoyIndexWithOptions(metaFile, Options{
+		Storage:  StorageFactoryOf(storageType),
+		Distance: distance,
+		Random:   random,
+	})
+}
+
+// NewMemoryGannoyIndex returns an index which keeps nodes and roots only in memory.
+// It can be written to disk by Snapshot.
+func NewMemoryGannoyIndex(tree, dim, K int, distance Distance, random Random) GannoyIndex {
+	return newGannoyIndex(newMemoryMeta(tree, dim, K), newMemoryStorage(), nil, nil, Options{Distance: distance, Random: random})
+}
+
+func newGannoyIndex(meta meta, storage Storage, changes *changeLog, queue *writeQueue, opts Options) GannoyIndex {
 	tree := meta.tree
 	dim := meta.dim
 	K := meta.K
-
-	ann := meta.treePath()
+	opts = opts.withDefaults(tree)
+	durability := meta.durability()
+	if opts.Durability != nil {
+		durability = *opts.Durability
+	}
 
 	gannoy := GannoyIndex{
-		meta:      meta,
-		tree:      tree,
-		dim:       dim,
-		distance:  distance,
-		random:    random,
-		K:         K,
-		nodes:     newNodes(ann, tree, dim, K),
-		numWorker: numWorker(tree),
-		buildChan: make(chan buildArgs, 1),
+		meta:       meta,
+		tree:       tree,
+		dim:        dim,
+		distance:   opts.Distance,
+		random:     opts.Random,
+		K:          K,
+		nodes:      newNodesWithStorage(storage),
+		numWorker:  opts.NumWorker,
+		readOnly:   opts.ReadOnly,
+		changes:    changes,
+		queue:      queue,
+		durability: durability,
+		syncState:  &syncState{},
+	}
+	if !gannoy.readOnly {
+		gannoy.locks = newWriteLocks(tree)
+		gannoy.buildChan = make(chan buildArgs, opts.Writers*buildGroupSize)
+		for i := 0; i < opts.Writers; i++ {
+			go gannoy.builder()
+		}
+		if durability.Policy == DURABILITY_INTERVAL {
+			gannoy.syncState.stop = make(chan struct{})
+			gannoy.syncState.done = make(chan struct{})
+			go gannoy.syncer(gannoy.syncState.stop, gannoy.syncState.done)
+		}
 	}
-	go gannoy.builder()
-	return gannoy, nil
+	return gannoy
 }
 
 func (g GannoyIndex) Tree() {
@@ -61,22 +100,35 @@ func (g GannoyIndex) Tree() {
 }
 
 func (g GannoyIndex) MetaFile() string {
-	return g.meta.file.Name()
+	return g.meta.path
+}
+
+func (g GannoyIndex) Dim() int {
+	return g.dim
 }
 
 func (g *GannoyIndex) AddItem(key int, w []float64) error {
+	if g.readOnly {
+		return ErrReadOnly
+	}
 	args := buildArgs{action: ADD, key: key, w: w, result: make(chan error)}
 	g.buildChan <- args
 	return <-args.result
 }
 
 func (g *GannoyIndex) RemoveItem(key int) error {
+	if g.readOnly {
+		return ErrReadOnly
+	}
 	args := buildArgs{action: DELETE, key: key, result: make(chan error)}
 	g.buildChan <- args
 	return <-args.result
 }
 
 func (g *GannoyIndex) UpdateItem(key int, w []float64) error {
+	if g.readOnly {
+		return ErrReadOnly
+	}
 	args := buildArgs{action: UPDATE, key: key, w: w, result: make(chan error)}
 	g.buildChan <- args
 	return <-args.result
@@ -87,7 +139,11 @@ func (g *GannoyIndex) GetNnsByKey(key, n, searchK int) ([]int, error) {
 	if err != nil || !m.isLeaf() {
 		return []int{}, fmt.Errorf("Not found")
 	}
-	return g.GetAllNns(m.v, n, searchK)
+	v, err := g.vector(m)
+	if err != nil {
+		return []int{}, err
+	}
+	return g.GetAllNns(v, n, searchK)
 }
 
 func (g *GannoyIndex) GetAllNns(v []float64, n, searchK int) ([]int, error) {
@@ -124,23 +180,77 @@ func (g *GannoyIndex) GetAllNns(v []float64, n, searchK int) ([]int, error) {
 	}
 
 	sort.Ints(nns)
-	nnsDist := make([]sorter, len(nns))
-	last := -1
-	dup := 0
+	uniq := make([]int, 0, len(nns))
 	for idx, j := range nns {
-		if j == last {
-			dup++
+		if idx > 0 && j == nns[idx-1] {
 			continue
 		}
-		last = j
+		uniq = append(uniq, j)
+	}
+
+	finder, ok := g.nodes.backend().(codeFinder)
+	if _, angular := g.distance.(Angular); ok && angular && g.meta.encoding == PQ {
+		// Score by PQ codes, then re-rank by full-precision vectors.
+		nnsDist, err := g.pqDistances(finder, v, uniq)
+		if err != nil {
+			return []int{}, err
+		}
+		return g.rerank(v, nnsDist, n)
+	}
+
+	nnsDist := make([]sorter, len(uniq))
+	for idx, j := range uniq {
 		node, err := g.nodes.getNode(j)
 		if err != nil {
 			return []int{}, err
 		}
-		nnsDist[idx-dup] = sorter{value: g.distance.distance(v, node.v), id: node.key}
+		nnsDist[idx] = sorter{value: g.distance.distance(v, node.v), id: node.key}
+	}
+
+	if g.Rerank > 0 && g.quantized() {
+		return g.rerank(v, nnsDist, n)
 	}
-	nnsDist = nnsDist[:len(nns)-dup]
+	return nearest(nnsDist, n), nil
+}
+
+// rerank recomputes distances of top candidates by full-precision vectors.
+func (g *GannoyIndex) rerank(v []float64, nnsDist []sorter, n int) ([]int, error) {
+	m := g.Rerank
+	if m < n {
+		m = n
+	}
+	candidates := nearest(nnsDist, m)
+	rerankDist := make([]sorter, 0, len(candidates))
+	for _, key := range candidates {
+		node, err := g.nodes.getNodeByKey(key)
+		if err != nil {
+			// removed while searching
+			continue
+		}
+		w, err := g.vector(node)
+		if err != nil {
+			return []int{}, err
+		}
+		rerankDist = append(rerankDist, sorter{value: g.distance.distance(v, w), id: key})
+	}
+	return nearest(rerankDist, n), nil
+}
 
+func (g GannoyIndex) quantized() bool {
+	return g.meta.encoding == INT8 || g.meta.encoding == PQ
+}
+
+// vector returns full-precision vector of the leaf if storage keeps it.
+func (g *GannoyIndex) vector(node Node) ([]float64, error) {
+	if finder, ok := g.nodes.backend().(vectorFinder); ok && node.isLeaf() {
+		if v, found, err := finder.findVector(node.id); found || err != nil {
+			return v, err
+		}
+	}
+	return node.v, nil
+}
+
+func nearest(nnsDist []sorter, n int) []int {
 	m := len(nnsDist)
 	p := m
 	if n < m {
@@ -153,17 +263,23 @@ func (g *GannoyIndex) GetAllNns(v []float64, n, searchK int) ([]int, error) {
 	for i := 0; i < p; i++ {
 		result[i] = nnsDist[m-1-i].id
 	}
-
-	return result, nil
+	return result
 }
 
-func (g *GannoyIndex) addItem(key int, w []float64) error {
+func (g *GannoyIndex) checkItem(key int, w []float64) error {
 	if len(w) != g.dim {
 		return fmt.Errorf("Dimension mismatch. expect %d, but %d.\n", g.dim, len(w))
 	}
 	if g.nodes.maps.isExist(key) {
 		return fmt.Errorf("Key [%d] is already exist.\n", key)
 	}
+	return nil
+}
+
+func (g *GannoyIndex) addItem(key int, w []float64) error {
+	if err := g.checkItem(key, w); err != nil {
+		return err
+	}
 	n := g.nodes.newNode()
 	n.key = key
 	n.v = w
@@ -177,9 +293,10 @@ func (g *GannoyIndex) addItem(key int, w []float64) error {
 	var wg sync.WaitGroup
 	wg.Add(g.tree)
 	buildChan := make(chan int, g.tree)
+	errs := make([]error, g.tree)
 	worker := func(n Node) {
 		for index := range buildChan {
-			g.build(index, g.meta.roots()[index], n)
+			errs[index] = g.build(index, n)
 			wg.Done()
 		}
 	}
@@ -194,6 +311,11 @@ func (g *GannoyIndex) addItem(key int, w []float64) error {
 
 	wg.Wait()
 	close(buildChan)
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
 	g.nodes.maps.add(n.id, key)
 
 	return nil
@@ -202,6 +324,9 @@ func (g *GannoyIndex) addItem(key int, w []float64) error {
 // Bulk insert. Currently, This method dosen't support mutex.
 // So, this method must be called only from converter.
 func (g *GannoyIndex) AddItems(keys []int, ws [][]float64) error {
+	if g.readOnly {
+		return ErrReadOnly
+	}
 	indices := make([]int, len(keys))
 	for i, key := range keys {
 		n := g.nodes.newNode()
@@ -215,8 +340,11 @@ func (g *GannoyIndex) AddItems(keys []int, ws [][]float64) error {
 		indices[i] = n.id
 	}
 	for index, _ := range g.meta.roots() {
-		m := g.makeTree(index, -1, indices)
-		err := g.meta.updateRoot(index, m)
+		m, err := g.makeTree(index, -1, indices)
+		if err != nil {
+			return err
+		}
+		err = g.meta.updateRoot(index, m)
 		if err != nil {
 			return err
 		}
@@ -224,65 +352,114 @@ func (g *GannoyIndex) AddItems(keys []int, ws [][]float64) error {
 	return nil
 }
 
-func (g *GannoyIndex) build(index, root int, n Node) {
+func (g *GannoyIndex) build(index int, n Node) error {
+	tree := g.locks.trees[index]
+	tree.RLock()
+	defer tree.RUnlock()
+
+	tree.root.Lock()
+	root := g.meta.roots()[index]
 	if root == -1 {
 		// 最初のノード
-		n.parents[index] = -1
-		n.save()
-		g.meta.updateRoot(index, n.id)
-		return
+		defer tree.root.Unlock()
+		if err := n.updateParents(index, -1); err != nil {
+			return err
+		}
+		return g.meta.updateRoot(index, n.id)
+	}
+
+	// 親(またはroot)をロックしたまま子をロックして降りる
+	id := root
+	parentId := -1
+	tree.nodes.lock(id)
+	found, err := g.nodes.getNode(id)
+	for err == nil && !found.isLeaf() && !found.isBucket() {
+		child := found.children[g.distance.side(found, n.v, g.random)]
+		tree.nodes.lock(child)
+		if parentId == -1 {
+			tree.root.Unlock()
+		} else {
+			tree.nodes.unlock(parentId)
+		}
+		parentId = id
+		id = child
+		found, err = g.nodes.getNode(id)
+	}
+	defer func() {
+		tree.nodes.unlock(id)
+		if parentId == -1 {
+			tree.root.Unlock()
+		} else {
+			tree.nodes.unlock(parentId)
+		}
+	}()
+	if err != nil {
+		return err
 	}
-	id := g.findBranchByVector(root, n.v)
-	found, _ := g.nodes.getNode(id)
 	// fmt.Printf("Found %d\n", item)
 
-	org_parent := found.parents[index]
 	if found.isBucket() && len(found.children) < g.K {
 		// ノードに余裕があれば追加
 		// fmt.Printf("pattern bucket\n")
-		n.updateParents(index, id)
+		if err := n.updateParents(index, id); err != nil {
+			return err
+		}
 		found.nDescendants++
 		found.children = append(found.children, n.id)
-		found.save()
+		return found.save()
+	}
+	// ノードが上限またはリーフノードであれば新しいノードを追加
+	return g.split(index, parentId, found, []int{n.id})
+}
+
+// split replaces the leaf or bucket found with a new subtree containing ids.
+func (g *GannoyIndex) split(index, parentId int, found Node, ids []int) error {
+	willDelete := false
+	var indices []int
+	if found.isLeaf() {
+		// fmt.Printf("pattern leaf node\n")
+		indices = append([]int{found.id}, ids...)
 	} else {
-		// ノードが上限またはリーフノードであれば新しいノードを追加
-		willDelete := false
-		var indices []int
-		if found.isLeaf() {
-			// fmt.Printf("pattern leaf node\n")
-			indices = []int{id, n.id}
-		} else {
-			// fmt.Printf("pattern full backet\n")
-			indices = append(found.children, n.id)
-			willDelete = true
-		}
+		// fmt.Printf("pattern full backet\n")
+		indices = append(found.children, ids...)
+		willDelete = true
+	}
 
-		m := g.makeTree(index, org_parent, indices)
-		if root == 0 || org_parent == 0 || org_parent == -1 {
-			// rootノードの入れ替え
-			g.meta.updateRoot(index, m)
-		} else {
-			parent, _ := g.nodes.getNode(org_parent)
-			parent.nDescendants++
-			children := make([]int, len(parent.children))
-			for i, child := range parent.children {
-				if child == id {
-					// 新しいノードに変更
-					children[i] = m
-				} else {
-					// 既存のノードのまま
-					children[i] = child
-				}
+	m, err := g.makeTree(index, parentId, indices)
+	if err != nil {
+		return err
+	}
+	if parentId == -1 {
+		// rootノードの入れ替え
+		if err := g.meta.updateRoot(index, m); err != nil {
+			return err
+		}
+	} else {
+		parent, err := g.nodes.getNode(parentId)
+		if err != nil {
+			return err
+		}
+		parent.nDescendants += len(ids)
+		children := make([]int, len(parent.children))
+		for i, child := range parent.children {
+			if child == found.id {
+				// 新しいノードに変更
+				children[i] = m
+			} else {
+				// 既存のノードのまま
+				children[i] = child
 			}
-			parent.children = children
-			parent.save()
-
 		}
-		if willDelete {
-			found.destroy()
-			g.nodes.free.push(found.id)
+		parent.children = children
+		if err := parent.save(); err != nil {
+			return err
 		}
 	}
+	if willDelete {
+		found.destroy()
+		g.nodes.free.push(found.id)
+	}
+	return nil
 }
 
 func (g *GannoyIndex) removeItem(key int) error {
@@ -319,6 +496,13 @@ func (g *GannoyIndex) removeItem(key int) error {
 }
 
 func (g *GannoyIndex) remove(root int, node Node) {
+	// 親と祖父母を書き換えるため木全体をロックする
+	tree := g.locks.trees[root]
+	tree.Lock()
+	defer tree.Unlock()
+
+	// 挿入によって親が変わっている可能性がある
+	node, _ = g.nodes.getNode(node.id)
 	if node.isRoot(root) {
 		g.meta.updateRoot(root, -1)
 		return
@@ -359,7 +543,10 @@ func (g *GannoyIndex) remove(root int, node Node) {
 					children = append(children, child)
 				}
 			}
-			grandParent.nDescendants--
+			// 分割ノードはnDescendantsがKを超えることで区別されるため、K以下にしない
+			if grandParent.nDescendants > g.K+1 {
+				grandParent.nDescendants--
+			}
 			grandParent.children = children
 			grandParent.save()
 		}
@@ -381,50 +568,71 @@ func (g GannoyIndex) findBranchByVector(id int, v []float64) int {
 	return g.findBranchByVector(node.children[side], v)
 }
 
-func (g *GannoyIndex) makeTree(root, parent int, ids []int) int {
+func (g *GannoyIndex) makeTree(root, parent int, ids []int) (int, error) {
 	if len(ids) == 1 {
-		n, _ := g.nodes.getNode(ids[0])
+		n, err := g.nodes.getNode(ids[0])
+		if err != nil {
+			return -1, err
+		}
 		if len(n.parents) == 0 {
 			n.parents = make([]int, g.tree)
 		}
-		n.updateParents(root, parent)
-		return ids[0]
+		return ids[0], n.updateParents(root, parent)
 	}
 
 	if len(ids) <= g.K {
-		m := g.nodes.newNode()
+		m := g.nodes.newInnerNode()
 		m.parents = make([]int, g.tree)
 		m.nDescendants = len(ids)
 		m.parents[root] = parent
 		m.children = ids
-		m.save()
+		if err := m.save(); err != nil {
+			return -1, err
+		}
 		for _, child := range ids {
-			c, _ := g.nodes.getNode(child)
+			c, err := g.nodes.getNode(child)
+			if err != nil {
+				return -1, err
+			}
 			if len(c.parents) == 0 {
 				c.parents = make([]int, g.tree)
 			}
-			c.updateParents(root, m.id)
+			if err := c.updateParents(root, m.id); err != nil {
+				return -1, err
+			}
 		}
-		return m.id
+		return m.id, nil
 	}
 
 	children := make([]Node, len(ids))
 	for i, id := range ids {
-		children[i], _ = g.nodes.getNode(id)
+		n, err := g.nodes.getNode(id)
+		if err != nil {
+			return -1, err
+		}
+		children[i] = n
 	}
 
 	childrenIds := [2][]int{[]int{}, []int{}}
 
-	m := g.nodes.newNode()
+	m := g.nodes.newInnerNode()
 	m.parents = make([]int, g.tree)
 	m.nDescendants = len(ids)
 	m.parents[root] = parent
 
 	m = g.distance.createSplit(children, g.random, m)
-	for _, id := range ids {
-		n, _ := g.nodes.getNode(id)
-		side := g.distance.side(m, n.v, g.random)
-		childrenIds[side] = append(childrenIds[side], id)
+	if q, ok := g.nodes.backend().(splitQuantizer); ok {
+		// Assign sides by the plane stored, which searches and inserts follow.
+		m.v = q.quantizeSplit(m.v)
+	}
+	for _, n := range children {
+		// Inserts descend by full-precision vectors of items.
+		v, err := g.vector(n)
+		if err != nil {
+			return -1, err
+		}
+		side := g.distance.side(m, v, g.random)
+		childrenIds[side] = append(childrenIds[side], n.id)
 	}
 
 	for len(childrenIds[0]) == 0 || len(childrenIds[1]) == 0 {
@@ -444,39 +652,152 @@ func (g *GannoyIndex) makeTree(root, parent int, ids []int) int {
 		flip = 1
 	}
 
-	m.save()
+	if err := m.save(); err != nil {
+		return -1, err
+	}
 	for side := 0; side < 2; side++ {
-		m.children[side^flip] = g.makeTree(root, m.id, childrenIds[side^flip])
+		child, err := g.makeTree(root, m.id, childrenIds[side^flip])
+		if err != nil {
+			return -1, err
+		}
+		m.children[side^flip] = child
 	}
-	m.save()
-
-	return m.id
+	return m.id, m.save()
 }
 
 type buildArgs struct {
 	action int
 	key    int
 	w      []float64
+	dir    string // destination of BACKUP
+	replay bool   // the write may have been applied already
 	result chan error
 }
 
 func (g *GannoyIndex) builder() {
 	for args := range g.buildChan {
-		switch args.action {
-		case ADD:
-			args.result <- g.addItem(args.key, args.w)
-		case DELETE:
-			args.result <- g.removeItem(args.key)
-		case UPDATE:
+		batch := g.drain(args)
+		for len(batch) > 0 {
+			if group := addGroup(batch); len(group) > 1 {
+				g.applyGroup(group)
+				batch = batch[len(group):]
+				continue
+			}
+			g.apply(batch[0])
+			batch = batch[1:]
+		}
+	}
+}
+
+func (g *GannoyIndex) apply(args buildArgs) {
+	switch args.action {
+	case ADD:
+		args.result <- g.mutate(args, func() error {
+			if args.replay && g.nodes.maps.isExist(args.key) {
+				if err := g.removeItem(args.key); err != nil {
+					return err
+				}
+			}
+			return g.addItem(args.key, args.w)
+		})
+	case DELETE:
+		args.result <- g.mutate(args, func() error {
+			if args.replay && !g.nodes.maps.isExist(args.key) {
+				return nil
+			}
+			return g.removeItem(args.key)
+		})
+	case UPDATE:
+		args.result <- g.mutate(args, func() error {
 			if g.nodes.maps.isExist(args.key) {
-				err := g.removeItem(args.key)
-				if err != nil {
-					args.result <- err
+				if err := g.removeItem(args.key); err != nil {
+					return err
 				}
 			}
-			args.result <- g.addItem(args.key, args.w)
+			return g.addItem(args.key, args.w)
+		})
+	case BACKUP:
+		resume := g.pauseWrites()
+		args.result <- g.backup(args.dir)
+		resume()
+	}
+}
+
+// mutate applies f concurrently with writes of other keys.
+func (g *GannoyIndex) mutate(args buildArgs, f func() error) error {
+	key := g.locks.key(args.key)
+	key.Lock()
+	defer key.Unlock()
+	defer g.lockWrites()()
+
+	if err := g.logChange(args, g.transaction(f)); err != nil {
+		return err
+	}
+	return g.written()
+}
+
+// close stops syncer, and closes storage and meta files of the index.
+func (g GannoyIndex) close() error {
+	err := g.stopSyncer()
+	if closer, ok := g.nodes.backend().(io.Closer); ok {
+		if e := closer.Close(); e != nil && err == nil {
+			err = e
 		}
 	}
+	if e := g.meta.close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// pauseWrites waits for writes being applied, and blocks new writes until the returned function is called.
+func (g *GannoyIndex) pauseWrites() func() {
+	if g.readOnly {
+		return func() {}
+	}
+	g.locks.write.Lock()
+	return g.locks.write.Unlock
+}
+
+// lockWrites locks writes shared with other builders, and returns a function to unlock it.
+// Storages supporting transaction are locked exclusively.
+func (g *GannoyIndex) lockWrites() func() {
+	if _, ok := g.nodes.backend().(transactional); ok {
+		g.locks.write.Lock()
+		return g.locks.write.Unlock
+	}
+	g.locks.write.RLock()
+	return g.locks.write.RUnlock
+}
+
+// transactional is implemented by storages which apply all writes
+// of a mutation atomically.
+type transactional interface {
+	begin() error
+	commit() error
+	rollback() error
+}
+
+// transaction runs f in a transaction of storage if it supports.
+func (g *GannoyIndex) transaction(f func() error) error {
+	tx, ok := g.nodes.backend().(transactional)
+	if !ok {
+		return f()
+	}
+	if err := tx.begin(); err != nil {
+		return err
+	}
+	if err := f(); err != nil {
+		tx.rollback()
+		// free and maps may be changed by the mutation.
+		g.nodes.reload()
+		return err
+	}
+	if err := tx.commit(); err != nil {
+		g.nodes.reload()
+		return err
+	}
+	return nil
 }
 
 func (g GannoyIndex) PrintTree() {
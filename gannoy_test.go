@@ -1,6 +1,7 @@
 package gannoy
 
 import (
+	"math/rand"
 	"os"
 	"testing"
 )
@@ -8,6 +9,7 @@ import (
 func TestGannoyIndexNotFound(t *testing.T) {
 	name := "test_gannoy_index_not_found.tree"
 	defer os.Remove(name)
+	defer os.Remove(leavesPath(name))
 	_, err := NewGannoyIndex("not_found.meta", Angular{}, RandRandom{})
 	if err == nil {
 		t.Errorf("NewGannoyIndex with not exist meta file should return error.")
@@ -25,6 +27,7 @@ func TestGannoyIndexAttribute(t *testing.T) {
 
 	treeFile := name + ".tree"
 	defer os.Remove(treeFile)
+	defer os.Remove(leavesPath(treeFile))
 	gannoy, _ := NewGannoyIndex(name+".meta", Angular{}, RandRandom{})
 
 	if gannoy.tree != tree {
@@ -45,6 +48,7 @@ func TestGannoyIndexAddItemAsRoot(t *testing.T) {
 
 	treeFile := name + ".tree"
 	defer os.Remove(treeFile)
+	defer os.Remove(leavesPath(treeFile))
 	gannoy, _ := NewGannoyIndex(name+".meta", Angular{}, RandRandom{})
 
 	// first item (be root)
@@ -84,6 +88,7 @@ func TestGannoyIndexAddItemToLeafNode(t *testing.T) {
 
 	treeFile := name + ".tree"
 	defer os.Remove(treeFile)
+	defer os.Remove(leavesPath(treeFile))
 	gannoy, _ := NewGannoyIndex(name+".meta", Angular{}, &TestLoopRandom{max: 1})
 
 	// add item to leaf node
@@ -120,6 +125,7 @@ func TestGannoyIndexAddItemToLeafNode(t *testing.T) {
 	//     0 [0] (10) [nDescendants: 1, v: [1.1 1.2 1.3]]
 	//     3 [40] (10) [nDescendants: 1, v: [1.1 1.2 1.3]]
 
+	first, _ := gannoy.nodes.getNodeByKey(0)
 	node, _ := gannoy.nodes.getNodeByKey(40)
 	for i := 0; i < tree; i++ {
 		parent, _ := gannoy.nodes.getNode(node.parents[i])
@@ -127,7 +133,7 @@ func TestGannoyIndexAddItemToLeafNode(t *testing.T) {
 			t.Errorf("GannoyIndex AddItem to leaf node should return node that contain 2 children.")
 		}
 		for _, child := range parent.children {
-			if child != node.id && child != 0 {
+			if child != node.id && child != first.id {
 				t.Errorf("GannoyIndex AddItem to leaf node should return node that contain 0[0] and 3[40].")
 			}
 		}
@@ -142,6 +148,7 @@ func TestGannoyIndexAddItemToBucketNode(t *testing.T) {
 
 	treeFile := name + ".tree"
 	defer os.Remove(treeFile)
+	defer os.Remove(leavesPath(treeFile))
 	gannoy, _ := NewGannoyIndex(name+".meta", Angular{}, &TestLoopRandom{max: 1})
 
 	// add item to bucket node
@@ -228,6 +235,7 @@ func TestGannoyIndexRemoveItem(t *testing.T) {
 
 	treeFile := name + ".tree"
 	defer os.Remove(treeFile)
+	defer os.Remove(leavesPath(treeFile))
 	gannoy, _ := NewGannoyIndex(name+".meta", Angular{}, &TestLoopRandom{max: 1})
 
 	// remove from bucket node
@@ -331,6 +339,7 @@ func TestGannoyIndexUpdateItem(t *testing.T) {
 
 	treeFile := name + ".tree"
 	defer os.Remove(treeFile)
+	defer os.Remove(leavesPath(treeFile))
 	gannoy, _ := NewGannoyIndex(name+".meta", Angular{}, &TestLoopRandom{max: 1})
 
 	// remove from bucket node
@@ -408,6 +417,7 @@ func TestGannoyIndexGetNnsByKey(t *testing.T) {
 
 	treeFile := name + ".tree"
 	defer os.Remove(treeFile)
+	defer os.Remove(leavesPath(treeFile))
 	gannoy, _ := NewGannoyIndex(name+".meta", Angular{}, &TestLoopRandom{max: 1})
 
 	// remove from bucket node
@@ -442,3 +452,150 @@ func TestGannoyIndexGetNnsByKey(t *testing.T) {
 		t.Errorf("GannoyIndex GetNnsByKey should not return error if key exist.")
 	}
 }
+
+func TestGannoyIndexFloat32Encoding(t *testing.T) {
+	name := "test_gannoy_index_float32_encoding"
+	CreateMetaWithOptions(".", name, 2, 3, 4, MetaOptions{Encoding: FLOAT32})
+	defer os.Remove(name + ".meta")
+
+	treeFile := name + ".tree"
+	defer os.Remove(treeFile)
+	defer os.Remove(leavesPath(treeFile))
+	gannoy, _ := NewGannoyIndex(name+".meta", Angular{}, &TestLoopRandom{max: 1})
+
+	items := [][]float64{
+		{1.1, 1.2, 1.3},
+		{-1.1, -1.2, -1.3},
+		{1.1, 1.2, 1.3},
+		{-1.1, -1.2, -1.3},
+		{-1.1, -1.2, -1.3},
+	}
+	for i, item := range items {
+		gannoy.AddItem(i*10, item)
+	}
+
+	file := gannoy.nodes.Storage.(*File)
+	if file.nodeSize != int64(1+4+4+4*2+4*2+4*3+4) { // with CRC32
+		t.Errorf("Node size of float32 encoding should use 4 bytes for each element, but %d", file.nodeSize)
+	}
+
+	nns, err := gannoy.GetNnsByKey(40, 3, -1)
+	if err != nil || len(nns) != 3 {
+		t.Errorf("GannoyIndex GetNnsByKey with float32 encoding should return specified size list.")
+	}
+	node, _ := gannoy.nodes.getNodeByKey(10)
+	if float32(node.v[0]) != float32(-1.1) {
+		t.Errorf("Leaf vector should be stored as float32, but %v", node.v)
+	}
+}
+
+func TestGannoyIndexInt8Encoding(t *testing.T) {
+	name := "test_gannoy_index_int8_encoding"
+	CreateMetaWithOptions(".", name, 2, 3, 3, MetaOptions{
+		Encoding: INT8,
+		Min:      []float64{-2.0, -2.0, -2.0},
+		Max:      []float64{2.0, 2.0, 2.0},
+	})
+	defer os.Remove(name + ".meta")
+
+	treeFile := name + ".tree"
+	defer os.Remove(treeFile)
+	defer os.Remove(leavesPath(treeFile))
+	defer os.Remove(name + ".vec")
+	gannoy, _ := NewGannoyIndex(name+".meta", Angular{}, &TestLoopRandom{max: 1})
+
+	items := [][]float64{
+		{1.0, 0.0, 0.0},
+		{1.0, 0.01, 0.0},
+		{1.0, 0.0, 0.02},
+		{-1.1, -1.2, -1.3},
+		{-1.1, -1.2, -1.3},
+	}
+	for i, item := range items {
+		gannoy.AddItem(i*10, item)
+	}
+
+	file := gannoy.nodes.Storage.(*File)
+	if file.nodeSize != int64(1+4+4+4*2+4*2+4+4) { // bucket node uses 4 bytes for K children, with CRC32
+		t.Errorf("Node size of int8 encoding should use 1 byte for each element, but %d", file.nodeSize)
+	}
+
+	node, _ := gannoy.nodes.getNodeByKey(10)
+	if node.v[1] == 0.01 {
+		t.Errorf("Leaf vector in tree file should be quantized, but %v", node.v)
+	}
+	v, err := gannoy.vector(node)
+	if err != nil || v[1] != 0.01 {
+		t.Errorf("Full-precision vector should be kept, but %v", v)
+	}
+
+	gannoy.Rerank = 5
+	nns, err := gannoy.GetNnsByKey(0, 2, 100)
+	if err != nil {
+		t.Errorf("GannoyIndex GetNnsByKey with rerank should not return error.")
+	}
+	expects := []int{0, 10}
+	for i, key := range expects {
+		if len(nns) != len(expects) || nns[i] != key {
+			t.Errorf("GannoyIndex GetNnsByKey with rerank should return %v, but %v", expects, nns)
+			break
+		}
+	}
+}
+
+func TestGannoyIndexInt8EncodingSplitSide(t *testing.T) {
+	name := "test_gannoy_index_int8_encoding_split_side"
+	CreateMetaWithOptions(".", name, 2, 3, 3, MetaOptions{
+		Encoding: INT8,
+		Min:      []float64{-2.0, -2.0, -2.0},
+		Max:      []float64{2.0, 2.0, 2.0},
+	})
+	defer os.Remove(name + ".meta")
+	defer os.Remove(name + ".tree")
+	defer os.Remove(name + ".leaves")
+	defer os.Remove(name + ".vec")
+	gannoy, _ := NewGannoyIndex(name+".meta", Angular{}, RandRandom{})
+
+	r := rand.New(rand.NewSource(1))
+	for key := 0; key < 200; key++ {
+		gannoy.AddItem(key, []float64{r.Float64()*4 - 2, r.Float64()*4 - 2, r.Float64()*4 - 2})
+	}
+
+	// Items must be on the side of split planes as they are stored.
+	for tree, root := range gannoy.meta.roots() {
+		checkSplitSide(t, gannoy, tree, root)
+	}
+}
+
+func checkSplitSide(t *testing.T, index GannoyIndex, tree, id int) []Node {
+	node, _ := index.nodes.getNode(id)
+	if node.isLeaf() {
+		return []Node{node}
+	}
+	leaves := []Node{}
+	for side, child := range node.children {
+		found := checkSplitSide(t, index, tree, child)
+		if !node.isBucket() && !isZeroSplit(node) {
+			for _, leaf := range found {
+				v, _ := index.vector(leaf)
+				margin := Angular{}.margin(node, v)
+				if (margin > 0 && side == 0) || (margin < 0 && side == 1) {
+					t.Errorf("Key %d should be on side %d of split %d in tree %d, but margin %f", leaf.key, side, id, tree, margin)
+				}
+			}
+		}
+		leaves = append(leaves, found...)
+	}
+	return leaves
+}
+
+// isZeroSplit returns whether sides of the split are assigned randomly.
+// Zero plane is stored as nearly zero by int8 encoding.
+func isZeroSplit(node Node) bool {
+	for _, x := range node.v {
+		if x > 0.01 || x < -0.01 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,220 @@
+package gannoy
+
+import (
+	"sort"
+	"sync"
+)
+
+// Maximum number of queued writes drained by a builder at once.
+const buildGroupSize = 64
+
+// drain returns args and writes queued after it without blocking.
+func (g *GannoyIndex) drain(args buildArgs) []buildArgs {
+	batch := []buildArgs{args}
+	for len(batch) < buildGroupSize {
+		select {
+		case next, ok := <-g.buildChan:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, next)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// addGroup returns leading additions of distinct keys in batch, which can be applied as a group.
+// Replayed additions are applied one by one.
+func addGroup(batch []buildArgs) []buildArgs {
+	keys := map[int]bool{}
+	for i, args := range batch {
+		if args.action != ADD || args.replay || keys[args.key] {
+			return batch[:i]
+		}
+		keys[args.key] = true
+	}
+	return batch
+}
+
+// applyGroup adds items of group at once, and returns the result of each item to its caller.
+func (g *GannoyIndex) applyGroup(group []buildArgs) {
+	unlock := g.lockKeys(group)
+	defer unlock()
+	defer g.lockWrites()()
+
+	errs := make([]error, len(group))
+	err := g.transaction(func() error {
+		return g.addGroupItems(group, errs)
+	})
+	for i, args := range group {
+		if err != nil {
+			errs[i] = err
+		}
+		errs[i] = g.logChange(args, errs[i])
+	}
+	// Applied items are synced at once.
+	if err := g.written(); err != nil {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+	}
+	for i, args := range group {
+		args.result <- errs[i]
+	}
+}
+
+// lockKeys locks keys of group in order of their locks, and returns a function to unlock them.
+func (g *GannoyIndex) lockKeys(group []buildArgs) func() {
+	stripes := map[int]bool{}
+	for _, args := range group {
+		stripes[ShardOf(args.key, keyLockStripes)] = true
+	}
+	ordered := make([]int, 0, len(stripes))
+	for stripe := range stripes {
+		ordered = append(ordered, stripe)
+	}
+	sort.Ints(ordered)
+	for _, stripe := range ordered {
+		g.locks.keys[stripe].Lock()
+	}
+	return func() {
+		for _, stripe := range ordered {
+			g.locks.keys[stripe].Unlock()
+		}
+	}
+}
+
+// addGroupItems saves leaves of group, and builds them into each tree at once.
+// Errors of items are set to errs.
+func (g *GannoyIndex) addGroupItems(group []buildArgs, errs []error) error {
+	nodes := []Node{}
+	for i, args := range group {
+		if errs[i] = g.checkItem(args.key, args.w); errs[i] != nil {
+			continue
+		}
+		n := g.nodes.newNode()
+		n.key = args.key
+		n.v = args.w
+		n.parents = make([]int, g.tree)
+		if errs[i] = n.save(); errs[i] != nil {
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(g.tree)
+	buildChan := make(chan int, g.tree)
+	buildErrs := make([]error, g.tree)
+	worker := func() {
+		for index := range buildChan {
+			buildErrs[index] = g.buildGroup(index, nodes)
+			wg.Done()
+		}
+	}
+
+	for i := 0; i < g.numWorker; i++ {
+		go worker()
+	}
+
+	for index, _ := range g.meta.roots() {
+		buildChan <- index
+	}
+
+	wg.Wait()
+	close(buildChan)
+	for _, err := range buildErrs {
+		if err != nil {
+			return err
+		}
+	}
+	for _, n := range nodes {
+		g.nodes.maps.add(n.id, n.key)
+	}
+	return nil
+}
+
+// buildGroup inserts nodes into the tree. Nodes reaching the same leaf or bucket
+// are added together, so that it is split at most once and the root is updated at most once.
+func (g *GannoyIndex) buildGroup(index int, nodes []Node) error {
+	tree := g.locks.trees[index]
+	tree.Lock()
+	defer tree.Unlock()
+
+	ids := make([]int, len(nodes))
+	for i, n := range nodes {
+		ids[i] = n.id
+	}
+	root := g.meta.roots()[index]
+	if root == -1 {
+		// 最初のノード
+		m, err := g.makeTree(index, -1, ids)
+		if err != nil {
+			return err
+		}
+		return g.meta.updateRoot(index, m)
+	}
+
+	// 追加先のノードごとにまとめる
+	targets := []int{}
+	parents := map[int]int{}
+	added := map[int][]int{}
+	for _, n := range nodes {
+		id, parentId, err := g.findBranchWithParent(root, n.v)
+		if err != nil {
+			return err
+		}
+		if _, ok := added[id]; !ok {
+			targets = append(targets, id)
+			parents[id] = parentId
+		}
+		added[id] = append(added[id], n.id)
+	}
+
+	for _, id := range targets {
+		found, err := g.nodes.getNode(id)
+		if err != nil {
+			return err
+		}
+		if found.isBucket() && len(found.children)+len(added[id]) <= g.K {
+			// ノードに余裕があれば追加
+			for _, child := range added[id] {
+				n, err := g.nodes.getNode(child)
+				if err != nil {
+					return err
+				}
+				if err := n.updateParents(index, id); err != nil {
+					return err
+				}
+			}
+			found.nDescendants += len(added[id])
+			found.children = append(found.children, added[id]...)
+			err = found.save()
+		} else {
+			err = g.split(index, parents[id], found, added[id])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findBranchWithParent returns the leaf or bucket for v under id, and its parent (-1 if it is the root).
+func (g GannoyIndex) findBranchWithParent(id int, v []float64) (int, int, error) {
+	parentId := -1
+	node, err := g.nodes.getNode(id)
+	for err == nil && !node.isLeaf() && !node.isBucket() {
+		parentId = id
+		id = node.children[g.distance.side(node, v, g.random)]
+		node, err = g.nodes.getNode(id)
+	}
+	return id, parentId, err
+}
@@ -0,0 +1,93 @@
+package gannoy
+
+import (
+	"os"
+	"testing"
+)
+
+func TestAddGroup(t *testing.T) {
+	batch := []buildArgs{
+		buildArgs{action: ADD, key: 1},
+		buildArgs{action: ADD, key: 2},
+		buildArgs{action: ADD, key: 1},
+		buildArgs{action: ADD, key: 3},
+	}
+	if group := addGroup(batch); len(group) != 2 {
+		t.Errorf("addGroup should stop at duplicated key, but %v", group)
+	}
+	batch[1].action = DELETE
+	if group := addGroup(batch); len(group) != 1 {
+		t.Errorf("addGroup should stop at other action, but %v", group)
+	}
+}
+
+func TestGannoyIndexApplyGroup(t *testing.T) {
+	tree := 2
+	dim := 3
+	K := 3
+	name := "test_gannoy_index_apply_group"
+	CreateMeta(".", name, tree, dim, K)
+	defer os.Remove(name + ".meta")
+	defer os.Remove(name + ".tree")
+	defer os.Remove(name + ".leaves")
+	defer os.Remove(name + ".changes")
+
+	index, _ := NewGannoyIndexWithOptions(name+".meta", Options{ChangeLog: true})
+	index.AddItem(0, []float64{1.1, 1.2, 1.3})
+
+	group := func(keys []int, vs [][]float64) []buildArgs {
+		args := make([]buildArgs, len(keys))
+		for i, key := range keys {
+			args[i] = buildArgs{action: ADD, key: key, w: vs[i], result: make(chan error, 1)}
+		}
+		return args
+	}
+
+	// Items exceeding K are split at once with existing leaf.
+	args := group([]int{0, 1, 2, 3, 4}, [][]float64{
+		[]float64{1.1, 1.2, 1.3},
+		[]float64{1.1, 1.2, 1.3},
+		[]float64{1.0, 1.2},
+		[]float64{-1.1, -1.2, -1.3},
+		[]float64{1.2, 1.1, 1.3},
+	})
+	index.applyGroup(args)
+	for i, arg := range args {
+		err := <-arg.result
+		if (i == 0 || i == 2) != (err != nil) {
+			t.Errorf("applyGroup should return result of key %d, but %v", arg.key, err)
+		}
+	}
+
+	// Items are added into a bucket.
+	args = group([]int{5, 6}, [][]float64{
+		[]float64{-1.2, -1.1, -1.3},
+		[]float64{1.3, 1.1, 1.2},
+	})
+	index.applyGroup(args)
+	for _, arg := range args {
+		if err := <-arg.result; err != nil {
+			t.Errorf("applyGroup should not return error, but %v", err)
+		}
+	}
+
+	keys := []int{0, 1, 3, 4, 5, 6}
+	for i, root := range index.meta.roots() {
+		found := map[int]bool{}
+		checkTree(t, index, i, root, -1, found)
+		if len(found) != len(keys) {
+			t.Errorf("Tree %d should contain %d items, but %d", i, len(keys), len(found))
+		}
+		for _, key := range keys {
+			if !found[key] {
+				t.Errorf("Tree %d should contain key %d", i, key)
+			}
+		}
+	}
+
+	// Only applied items are logged.
+	stats, _ := index.Stats()
+	if stats.ChangeSeq != 6 {
+		t.Errorf("applyGroup should log applied items, but seq %d", stats.ChangeSeq)
+	}
+}
@@ -0,0 +1,136 @@
+package gannoy
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Current version of meta and tree file format.
+// Files without header are version 0. Since version 2, leaf vectors are
+// kept in leaves file instead of tree file.
+const formatVersion = 2
+
+// headerSize is the size of header at the beginning of meta and tree files:
+// magic(4) version(2) endian(1) metric(1) durability(1) sync interval in milliseconds(4) reserved(3).
+// Durability is used only in meta file.
+const headerSize = 16
+
+var (
+	metaMagic = [4]byte{'G', 'N', 'Y', 'M'}
+	treeMagic = [4]byte{'G', 'N', 'Y', 'T'}
+)
+
+// Byte orders of file body.
+const (
+	BIG_ENDIAN int = iota
+	LITTLE_ENDIAN
+)
+
+// Metrics of distance.
+const (
+	ANGULAR int = iota
+)
+
+type header struct {
+	magic        [4]byte
+	version      int
+	endian       int
+	metric       int
+	durability   int
+	syncInterval int // milliseconds
+}
+
+func newHeader(magic [4]byte, endian, metric int) header {
+	return header{magic: magic, version: formatVersion, endian: endian, metric: metric}
+}
+
+// legacy returns whether the file has no header.
+func (h header) legacy() bool {
+	return h.version == 0
+}
+
+// size returns the number of bytes before the body of file.
+func (h header) size() int64 {
+	if h.legacy() {
+		return 0
+	}
+	return headerSize
+}
+
+// order returns byte order of file body. Files without header are big-endian.
+func (h header) order() binary.ByteOrder {
+	if h.endian == LITTLE_ENDIAN {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+func (h header) bytes() []byte {
+	b := make([]byte, headerSize)
+	copy(b[0:4], h.magic[:])
+	binary.BigEndian.PutUint16(b[4:6], uint16(h.version))
+	b[6] = byte(h.endian)
+	b[7] = byte(h.metric)
+	b[8] = byte(h.durability)
+	binary.BigEndian.PutUint32(b[9:13], uint32(h.syncInterval))
+	return b
+}
+
+// parseHeader parses b as header of magic.
+// If b does not start with magic, it returns header of version 0.
+func parseHeader(magic [4]byte, b []byte) (header, error) {
+	h := header{magic: magic}
+	if len(b) < headerSize || string(b[0:4]) != string(magic[:]) {
+		return h, nil
+	}
+	h.version = int(binary.BigEndian.Uint16(b[4:6]))
+	h.endian = int(b[6])
+	h.metric = int(b[7])
+	h.durability = int(b[8])
+	h.syncInterval = int(binary.BigEndian.Uint32(b[9:13]))
+	if h.version == 0 || h.version > formatVersion {
+		return h, fmt.Errorf("Unsupported format version: %d.", h.version)
+	}
+	if h.endian != BIG_ENDIAN && h.endian != LITTLE_ENDIAN {
+		return h, fmt.Errorf("Unsupported endian: %d.", h.endian)
+	}
+	if h.metric != ANGULAR {
+		return h, fmt.Errorf("Unknown metric: %d.", h.metric)
+	}
+	if h.durability > DURABILITY_INTERVAL {
+		return h, fmt.Errorf("Unknown durability: %d.", h.durability)
+	}
+	return h, nil
+}
+
+func metricOf(distance Distance) (int, error) {
+	switch distance.(type) {
+	case Angular:
+		return ANGULAR, nil
+	default:
+		return -1, fmt.Errorf("Unknown distance: %T.", distance)
+	}
+}
+
+func endianName(endian int) string {
+	switch endian {
+	case BIG_ENDIAN:
+		return "big"
+	case LITTLE_ENDIAN:
+		return "little"
+	default:
+		return "unknown"
+	}
+}
+
+// EndianFromName returns endian constant from name such as "little".
+func EndianFromName(name string) (int, error) {
+	switch name {
+	case "big":
+		return BIG_ENDIAN, nil
+	case "little":
+		return LITTLE_ENDIAN, nil
+	default:
+		return -1, fmt.Errorf("Unknown endian: %s.", name)
+	}
+}
@@ -0,0 +1,46 @@
+package gannoy
+
+import (
+	"testing"
+)
+
+func TestParseHeader(t *testing.T) {
+	h, err := parseHeader(treeMagic, newHeader(treeMagic, BIG_ENDIAN, ANGULAR).bytes())
+	if err != nil {
+		t.Errorf("parseHeader should not return error, but %v", err)
+	}
+	if h.version != formatVersion || h.endian != BIG_ENDIAN || h.metric != ANGULAR {
+		t.Errorf("parseHeader should return written header, but %v", h)
+	}
+	if h.size() != headerSize {
+		t.Errorf("header size should be %d, but %d", headerSize, h.size())
+	}
+}
+
+func TestParseHeaderLegacy(t *testing.T) {
+	// Meta file without header starts with tree.
+	h, err := parseHeader(metaMagic, []byte{0, 0, 0, 2, 0, 0, 0, 3, 0, 0, 0, 4, 255, 255, 255, 255})
+	if err != nil || !h.legacy() || h.size() != 0 {
+		t.Errorf("parseHeader of file without header should return version 0, but %v", h)
+	}
+
+	// Header of other file.
+	h, _ = parseHeader(metaMagic, newHeader(treeMagic, BIG_ENDIAN, ANGULAR).bytes())
+	if !h.legacy() {
+		t.Errorf("parseHeader should not accept header of other magic.")
+	}
+}
+
+func TestParseHeaderUnsupported(t *testing.T) {
+	future := newHeader(metaMagic, BIG_ENDIAN, ANGULAR)
+	future.version = formatVersion + 1
+	if _, err := parseHeader(metaMagic, future.bytes()); err == nil {
+		t.Errorf("parseHeader of future version should return error.")
+	}
+
+	unknown := newHeader(metaMagic, BIG_ENDIAN, ANGULAR)
+	unknown.metric = 100
+	if _, err := parseHeader(metaMagic, unknown.bytes()); err == nil {
+		t.Errorf("parseHeader of unknown metric should return error.")
+	}
+}
@@ -0,0 +1,311 @@
+package gannoy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+var leafMagic = [4]byte{'G', 'N', 'Y', 'L'}
+
+// Records read at once by scan.
+const leafScanRecords = 1024
+
+// Number of locks serialising reads and writes of records in process.
+const leafLockStripes = 64
+
+// leaves keeps leaves densely in a file separated from tree structure.
+// Leaves have no record in tree file, and their ids refer slots with leafIdBit.
+// Each record is free(1) key(4) parents(4*tree) code crc(4).
+type leaves struct {
+	file   *os.File
+	tree   int
+	codec  codec
+	order  binary.ByteOrder
+	locker Locker
+
+	mu    sync.Mutex // guards count
+	count int
+
+	// Range locks of locker do not exclude goroutines sharing file,
+	// so that records are also locked by stripes of slots.
+	stripes [leafLockStripes]sync.RWMutex
+}
+
+// leafIdBit marks ids of leaves kept in leaves file.
+const leafIdBit = 1 << 30
+
+func leafId(slot int) int {
+	return slot | leafIdBit
+}
+
+func leafSlot(id int) int {
+	return id &^ leafIdBit
+}
+
+func newLeaves(filename string, tree int, codec codec, h header, readOnly bool) (*leaves, error) {
+	if readOnly {
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		return openLeaves(file, nil, tree, codec, h, noLocker{})
+	}
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return openLeaves(file, file, tree, codec, h, newLocker())
+}
+
+func openLeaves(file, appendFile *os.File, tree int, codec codec, h header, locker Locker) (*leaves, error) {
+	h.magic = leafMagic
+	h, err := initHeader(file, appendFile, h)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	l := &leaves{
+		file:   file,
+		tree:   tree,
+		codec:  codec,
+		order:  h.order(),
+		locker: locker,
+	}
+	l.count = int((l.size() - headerSize) / l.recordSize())
+	return l, nil
+}
+
+// leavesPath returns path of leaves file next to tree file.
+func leavesPath(treePath string) string {
+	return strings.TrimSuffix(treePath, filepath.Ext(treePath)) + ".leaves"
+}
+
+func (l *leaves) recordSize() int64 {
+	return int64(1 + 4 + 4*l.tree + l.codec.size() + 4)
+}
+
+func (l *leaves) offsetOfCode() int {
+	return 1 + 4 + 4*l.tree
+}
+
+func (l *leaves) offset(slot int) int64 {
+	return headerSize + int64(slot)*l.recordSize()
+}
+
+// allocate returns a new slot at the end of file.
+// Slots of removed leaves are reused by ids of them.
+func (l *leaves) allocate() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	slot := l.count
+	l.count++
+	return slot
+}
+
+func (l *leaves) slots() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.count
+}
+
+func (l *leaves) write(slot int, n Node) error {
+	b := make([]byte, l.recordSize())
+	if n.free {
+		b[0] = 1
+	}
+	l.order.PutUint32(b[1:5], uint32(n.key))
+	for i := 0; i < l.tree; i++ {
+		parent := 0
+		if i < len(n.parents) {
+			parent = n.parents[i]
+		}
+		l.order.PutUint32(b[5+i*4:5+i*4+4], uint32(parent))
+	}
+	l.codec.encode(b[l.offsetOfCode():], n.v)
+	l.sum(b)
+
+	defer l.lock(slot)()
+	offset := l.offset(slot)
+	err := l.locker.WriteLock(l.file.Fd(), offset, l.recordSize())
+	if err != nil {
+		return err
+	}
+	defer l.locker.UnLock(l.file.Fd(), offset, l.recordSize())
+
+	_, err = syscall.Pwrite(int(l.file.Fd()), b, offset)
+	return err
+}
+
+// updateParent rewrites parent of the leaf with its CRC32.
+func (l *leaves) updateParent(slot, rootIndex, parent int) error {
+	defer l.lock(slot)()
+	offset := l.offset(slot)
+	err := l.locker.WriteLock(l.file.Fd(), offset, l.recordSize())
+	if err != nil {
+		return err
+	}
+	defer l.locker.UnLock(l.file.Fd(), offset, l.recordSize())
+
+	b := make([]byte, l.recordSize())
+	if _, err := syscall.Pread(int(l.file.Fd()), b, offset); err != nil {
+		return err
+	}
+	if err := l.verify(slot, b); err != nil {
+		return err
+	}
+	l.order.PutUint32(b[5+rootIndex*4:5+rootIndex*4+4], uint32(parent))
+	l.sum(b)
+	_, err = syscall.Pwrite(int(l.file.Fd()), b, offset)
+	return err
+}
+
+// lock locks the record of slot for writing, and returns a function to unlock it.
+func (l *leaves) lock(slot int) func() {
+	stripe := &l.stripes[slot%leafLockStripes]
+	stripe.Lock()
+	return stripe.Unlock
+}
+
+// rlock locks n records from slot for reading in order of stripes,
+// and returns a function to unlock them.
+func (l *leaves) rlock(slot, n int) func() {
+	if n > leafLockStripes {
+		n = leafLockStripes
+	}
+	stripes := make([]int, n)
+	for i := range stripes {
+		stripes[i] = (slot + i) % leafLockStripes
+	}
+	sort.Ints(stripes)
+	for _, i := range stripes {
+		l.stripes[i].RLock()
+	}
+	return func() {
+		for _, i := range stripes {
+			l.stripes[i].RUnlock()
+		}
+	}
+}
+
+func (l *leaves) sum(b []byte) {
+	l.order.PutUint32(b[len(b)-4:], crc32.Checksum(b[:len(b)-4], crcTable))
+}
+
+// verify checks CRC32 of the record.
+func (l *leaves) verify(slot int, b []byte) error {
+	size := len(b)
+	if crc32.Checksum(b[:size-4], crcTable) != l.order.Uint32(b[size-4:]) {
+		return fmt.Errorf("Leaf %d is corrupted.", slot)
+	}
+	return nil
+}
+
+// records reads records from slot into b, and verifies them.
+func (l *leaves) records(slot int, b []byte) error {
+	defer l.rlock(slot, len(b)/int(l.recordSize()))()
+	offset := l.offset(slot)
+	err := l.locker.ReadLock(l.file.Fd(), offset, int64(len(b)))
+	if err != nil {
+		return err
+	}
+	defer l.locker.UnLock(l.file.Fd(), offset, int64(len(b)))
+
+	n, err := syscall.Pread(int(l.file.Fd()), b, offset)
+	if err != nil {
+		return err
+	}
+	if n != len(b) {
+		return fmt.Errorf("Leaf %d is out of file.", slot)
+	}
+	size := int(l.recordSize())
+	for i := 0; i < len(b); i += size {
+		if err := l.verify(slot+i/size, b[i:i+size]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// node reads the leaf in slot.
+func (l *leaves) node(slot int) (Node, error) {
+	b := make([]byte, l.recordSize())
+	if err := l.records(slot, b); err != nil {
+		return Node{}, err
+	}
+	return l.decode(b), nil
+}
+
+// decode decodes verified record into leaf.
+func (l *leaves) decode(b []byte) Node {
+	node := Node{
+		free:         b[0] != 0,
+		nDescendants: 1,
+		key:          int(int32(l.order.Uint32(b[1:5]))),
+		parents:      make([]int, l.tree),
+		children:     []int{0, 0},
+		v:            l.codec.decode(b[l.offsetOfCode():]),
+	}
+	for i := 0; i < l.tree; i++ {
+		node.parents[i] = int(int32(l.order.Uint32(b[5+i*4 : 5+i*4+4])))
+	}
+	return node
+}
+
+func (l *leaves) read(slot int) (int, []float64, error) {
+	key, code, err := l.code(slot)
+	if err != nil {
+		return -1, nil, err
+	}
+	return key, l.codec.decode(code), nil
+}
+
+func (l *leaves) code(slot int) (int, []byte, error) {
+	b := make([]byte, l.recordSize())
+	if err := l.records(slot, b); err != nil {
+		return -1, nil, err
+	}
+	return l.recordCode(b)
+}
+
+// recordCode returns key and code of verified record.
+func (l *leaves) recordCode(b []byte) (int, []byte, error) {
+	offset := l.offsetOfCode()
+	return int(int32(l.order.Uint32(b[1:5]))), b[offset : offset+l.codec.size()], nil
+}
+
+// scan reads records between slots sequentially and calls f for live leaves.
+func (l *leaves) scan(start, end int, f func(key int, v []float64)) error {
+	size := int(l.recordSize())
+	for slot := start; slot < end; slot += leafScanRecords {
+		n := end - slot
+		if n > leafScanRecords {
+			n = leafScanRecords
+		}
+		b := make([]byte, n*size)
+		if err := l.records(slot, b); err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			record := b[i*size : (i+1)*size]
+			if record[0] != 0 {
+				continue
+			}
+			f(int(int32(l.order.Uint32(record[1:5]))), l.codec.decode(record[l.offsetOfCode():]))
+		}
+	}
+	return nil
+}
+
+func (l *leaves) size() int64 {
+	info, _ := l.file.Stat()
+	return info.Size()
+}
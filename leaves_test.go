@@ -0,0 +1,130 @@
+package gannoy
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+)
+
+func TestLeavesWriteAndScan(t *testing.T) {
+	name := "test_leaves_write_and_scan.leaves"
+	defer os.Remove(name)
+	l, _ := newLeaves(name, 2, float64Codec{dim: 3, order: binary.BigEndian}, newHeader(leafMagic, BIG_ENDIAN, ANGULAR), false)
+
+	for i := 0; i < 3; i++ {
+		slot := l.allocate()
+		l.write(slot, Node{key: i * 10, free: i == 1, v: []float64{float64(i), 1.2, 1.3}})
+	}
+	if l.slots() != 3 {
+		t.Errorf("Leaves should have %d slots, but %d", 3, l.slots())
+	}
+
+	key, v, err := l.read(2)
+	if err != nil || key != 20 || v[0] != 2.0 {
+		t.Errorf("Leaves read should return written leaf, but %d %v (%v)", key, v, err)
+	}
+
+	keys := []int{}
+	l.scan(0, l.slots(), func(key int, v []float64) {
+		keys = append(keys, key)
+	})
+	if len(keys) != 2 || keys[0] != 0 || keys[1] != 20 {
+		t.Errorf("Leaves scan should skip free leaves, but %v", keys)
+	}
+
+	// corrupt key
+	f, _ := os.OpenFile(name, os.O_RDWR, 0)
+	f.WriteAt([]byte{1}, l.offset(0)+1)
+	f.Close()
+	if _, _, err := l.read(0); err == nil {
+		t.Errorf("Leaves read of corrupted leaf should return error.")
+	}
+}
+
+func TestFileLeaves(t *testing.T) {
+	name := "test_file_leaves.tree"
+	defer os.Remove(name)
+	defer os.Remove(leavesPath(name))
+	file, _ := newFile(name, 2, 3, 4)
+
+	id, _ := file.Create(Node{
+		key:          10,
+		nDescendants: 1,
+		parents:      []int{2, 3},
+		children:     []int{0, 0},
+		v:            []float64{1.1, 1.2, 1.3},
+	})
+	if size := file.leaves.size(); size != headerSize+int64(1+4+4*2+8*3+4) {
+		t.Errorf("File should keep leaf in leaves file, but size %d", size)
+	}
+	if size := file.treeSize(); size != headerSize {
+		t.Errorf("File should not keep leaf in tree file, but size %d", size)
+	}
+	if !file.isLeafId(id) {
+		t.Errorf("File should give leaf id in leaves file, but %d", id)
+	}
+
+	// Leaf keeps its slot on update.
+	file.Update(Node{
+		id:           id,
+		key:          10,
+		nDescendants: 1,
+		parents:      []int{2, 3},
+		children:     []int{0, 0},
+		v:            []float64{2.1, 2.2, 2.3},
+	})
+	if slots, _ := file.leafSlots(); slots != 1 {
+		t.Errorf("File update of leaf should reuse its slot, but %d slots", slots)
+	}
+	node, _ := file.Find(id)
+	if node.v[0] != 2.1 || node.key != 10 || node.parents[1] != 3 {
+		t.Errorf("File find should read leaf from leaves file, but %v", node)
+	}
+
+	// Removed leaf is not scanned.
+	file.Delete(node)
+	count := 0
+	file.scanLeaves(0, 1, func(key int, v []float64) { count++ })
+	if count != 0 {
+		t.Errorf("File should not scan removed leaf, but %d", count)
+	}
+}
+
+func TestGannoyIndexLeavesReuse(t *testing.T) {
+	name := "test_gannoy_index_leaves_reuse"
+	CreateMeta(".", name, 2, 3, 4)
+	defer os.Remove(name + ".meta")
+	defer os.Remove(name + ".tree")
+	defer os.Remove(name + ".leaves")
+
+	gannoy, _ := NewGannoyIndex(name+".meta", Angular{}, &TestLoopRandom{max: 1})
+	for i := 0; i < 20; i++ {
+		gannoy.AddItem(i, []float64{1.1, float64(i), 1.3})
+	}
+	file := gannoy.nodes.backend().(*File)
+	nodes, slots := file.treeNodes(), file.leaves.slots()
+	if slots != 20 {
+		t.Errorf("Leaves file should keep all leaves, but %d", slots)
+	}
+	for id := 0; id < nodes; id++ {
+		if n, _ := file.Find(id); n.isLeaf() {
+			t.Errorf("Tree file should keep only inner nodes, but %d is leaf", id)
+		}
+	}
+
+	// Removed leaves and nodes are reused by their kinds.
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 10; i++ {
+			gannoy.RemoveItem(i)
+		}
+		for i := 0; i < 10; i++ {
+			gannoy.AddItem(i, []float64{1.1, float64(i), 1.3})
+		}
+	}
+	if file.leaves.slots() != slots {
+		t.Errorf("Leaves file should reuse slots of removed leaves, but %d slots", file.leaves.slots())
+	}
+	if file.treeNodes() > 2*nodes {
+		t.Errorf("Tree file should reuse removed nodes, but %d nodes", file.treeNodes())
+	}
+}
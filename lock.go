@@ -87,3 +87,19 @@ func (f Flock) UnLock(fd uintptr, start, len int64) error {
 func (f Flock) flock(fd uintptr, how int) error {
 	return syscall.Flock(int(fd), how)
 }
+
+// noLocker does not lock files opened read-only, which are never written.
+type noLocker struct {
+}
+
+func (l noLocker) ReadLock(fd uintptr, start, len int64) error {
+	return nil
+}
+
+func (l noLocker) WriteLock(fd uintptr, start, len int64) error {
+	return nil
+}
+
+func (l noLocker) UnLock(fd uintptr, start, len int64) error {
+	return nil
+}
@@ -32,6 +32,15 @@ func (m *Maps) remove(key int) {
 	delete(m.keyToId, key)
 }
 
+func (m *Maps) clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key := range m.keyToId {
+		delete(m.keyToId, key)
+	}
+}
+
 func (m Maps) getId(key int) (int, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -47,3 +56,32 @@ func (m Maps) isExist(key int) bool {
 	_, err := m.getId(key)
 	return err == nil
 }
+
+func (m Maps) count() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.keyToId)
+}
+
+func (m Maps) ids() []int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids := make([]int, 0, len(m.keyToId))
+	for _, id := range m.keyToId {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (m Maps) keys() []int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := make([]int, 0, len(m.keyToId))
+	for key, _ := range m.keyToId {
+		keys = append(keys, key)
+	}
+	return keys
+}
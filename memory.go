@@ -0,0 +1,127 @@
+package gannoy
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// MemoryStorage keeps nodes in memory.
+// Ids of nodes copied from other storage are kept, and new nodes are given ids after them.
+type MemoryStorage struct {
+	mu    sync.RWMutex
+	nodes map[int]Node
+	next  int
+}
+
+func newMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{nodes: map[int]Node{}}
+}
+
+// newMemoryStorageFrom copies all nodes of storage into memory.
+func newMemoryStorageFrom(storage Storage) *MemoryStorage {
+	m := newMemoryStorage()
+	iterator := make(chan Node)
+	go storage.Iterate(iterator)
+	for node := range iterator {
+		m.nodes[node.id] = copyNode(node)
+		if node.id >= m.next {
+			m.next = node.id + 1
+		}
+	}
+	return m
+}
+
+func (m *MemoryStorage) Create(n Node) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := m.next
+	m.next++
+	n.id = id
+	m.nodes[id] = copyNode(n)
+	return id, nil
+}
+
+func (m *MemoryStorage) Find(id int) (Node, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stored, ok := m.nodes[id]
+	if !ok {
+		return Node{id: id, storage: m}, fmt.Errorf("Node %d is not found.", id)
+	}
+	node := copyNode(stored)
+	node.storage = m
+	return node, nil
+}
+
+func (m *MemoryStorage) Update(n Node) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.nodes[n.id]; !ok {
+		return fmt.Errorf("Node %d is not found.", n.id)
+	}
+	m.nodes[n.id] = copyNode(n)
+	return nil
+}
+
+func (m *MemoryStorage) UpdateParent(id, rootIndex, parent int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.nodes[id]; !ok {
+		return fmt.Errorf("Node %d is not found.", id)
+	}
+	m.nodes[id].parents[rootIndex] = parent
+	return nil
+}
+
+func (m *MemoryStorage) Delete(n Node) error {
+	n.free = true
+	return m.Update(n)
+}
+
+// Iterate sends nodes in order of ids.
+func (m *MemoryStorage) Iterate(c chan Node) {
+	m.mu.RLock()
+	ids := make([]int, 0, len(m.nodes))
+	for id := range m.nodes {
+		ids = append(ids, id)
+	}
+	m.mu.RUnlock()
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		n, err := m.Find(id)
+		if err != nil {
+			break
+		}
+		c <- n
+	}
+	close(c)
+}
+
+func (m *MemoryStorage) nodeCount() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.nodes)
+}
+
+// size is always 0 because nodes are not on disk.
+func (m *MemoryStorage) size() int64 {
+	return 0
+}
+
+// copyNode copies slices of node so that callers can not modify stored nodes.
+func copyNode(n Node) Node {
+	c := n
+	c.storage = nil
+	c.isNewRecord = false
+	c.parents = append([]int{}, n.parents...)
+	c.children = append([]int{}, n.children...)
+	c.v = append([]float64{}, n.v...)
+	return c
+}
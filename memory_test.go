@@ -0,0 +1,168 @@
+package gannoy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	storage := newMemoryStorage()
+
+	_, err := storage.Find(0)
+	if err == nil {
+		t.Errorf("MemoryStorage Find for not exist node should return error.")
+	}
+
+	node := Node{
+		key:          10,
+		nDescendants: 1,
+		parents:      []int{2, 3},
+		children:     []int{0, 0},
+		v:            []float64{1.1, 1.2, 1.3},
+	}
+	id, err := storage.Create(node)
+	if err != nil || id != 0 {
+		t.Errorf("MemoryStorage Create should return id 0, but %d", id)
+	}
+
+	found, _ := storage.Find(id)
+	if found.key != 10 || found.storage != storage {
+		t.Errorf("MemoryStorage Find should return created node, but %v", found)
+	}
+
+	// Modifying found node does not change stored node until update.
+	found.v[0] = 2.1
+	stored, _ := storage.Find(id)
+	if stored.v[0] != 1.1 {
+		t.Errorf("MemoryStorage should keep copy of node, but %v", stored.v)
+	}
+	storage.Update(found)
+	stored, _ = storage.Find(id)
+	if stored.v[0] != 2.1 {
+		t.Errorf("MemoryStorage Update should update node, but %v", stored.v)
+	}
+
+	storage.UpdateParent(id, 1, 30)
+	stored, _ = storage.Find(id)
+	if stored.parents[1] != 30 {
+		t.Errorf("MemoryStorage UpdateParent should update parent, but %v", stored.parents)
+	}
+
+	storage.Delete(stored)
+	stored, _ = storage.Find(id)
+	if !stored.free {
+		t.Errorf("MemoryStorage Delete should mark node free.")
+	}
+
+	iterator := make(chan Node)
+	go storage.Iterate(iterator)
+	count := 0
+	for _ = range iterator {
+		count++
+	}
+	if count != 1 {
+		t.Errorf("MemoryStorage Iterate should return all nodes, but %d", count)
+	}
+}
+
+func TestMemoryGannoyIndexSnapshot(t *testing.T) {
+	name := "test_memory_gannoy_index_snapshot"
+	defer os.Remove(name + ".meta")
+	defer os.Remove(name + ".tree")
+	defer os.Remove(name + ".leaves")
+
+	gannoy := NewMemoryGannoyIndex(2, 3, 4, Angular{}, &TestLoopRandom{max: 1})
+	items := [][]float64{
+		{1.1, 1.2, 1.3},
+		{-1.1, -1.2, -1.3},
+		{1.1, 1.2, 1.3},
+		{-1.1, -1.2, -1.3},
+		{-1.1, -1.2, -1.3},
+	}
+	for i, item := range items {
+		if err := gannoy.AddItem(i*10, item); err != nil {
+			t.Errorf("Memory GannoyIndex AddItem should not return error.")
+		}
+	}
+	nns, err := gannoy.GetNnsByKey(40, 3, -1)
+	if err != nil || len(nns) != 3 {
+		t.Errorf("Memory GannoyIndex GetNnsByKey should return specified size list, but %v", nns)
+	}
+
+	if err := gannoy.Snapshot(".", name); err != nil {
+		t.Errorf("GannoyIndex Snapshot should not return error, but %v", err)
+	}
+
+	loaded, err := NewGannoyIndex(name+".meta", Angular{}, &TestLoopRandom{max: 1})
+	if err != nil {
+		t.Errorf("Snapshot should be loaded as file database.")
+	}
+	for i, root := range gannoy.meta.roots() {
+		n, _ := gannoy.nodes.getNode(root)
+		m, _ := loaded.nodes.getNode(loaded.meta.roots()[i])
+		if m.nDescendants != n.nDescendants || len(m.children) != len(n.children) {
+			t.Errorf("Snapshot should keep roots %v, but %v", n, m)
+			break
+		}
+	}
+	loadedNns, _ := loaded.GetNnsByKey(40, 3, -1)
+	for i, key := range nns {
+		if loadedNns[i] != key {
+			t.Errorf("Snapshot should return same result %v, but %v", nns, loadedNns)
+			break
+		}
+	}
+}
+
+func TestGannoyIndexSnapshotCloseFiles(t *testing.T) {
+	gannoy := NewMemoryGannoyIndex(2, 3, 4, Angular{}, &TestLoopRandom{max: 1})
+	for i := 0; i < 10; i++ {
+		gannoy.AddItem(i, []float64{1.1, 1.2, float64(i)})
+	}
+
+	fds, _ := ioutil.ReadDir("/proc/self/fd")
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("test_gannoy_index_snapshot_close_files_%d", i)
+		if err := gannoy.Snapshot(".", name); err != nil {
+			t.Errorf("GannoyIndex Snapshot should not return error, but %v", err)
+		}
+		os.Remove(name + ".meta")
+		os.Remove(name + ".tree")
+		os.Remove(name + ".leaves")
+	}
+	after, _ := ioutil.ReadDir("/proc/self/fd")
+	if len(after) > len(fds)+5 {
+		t.Errorf("Snapshot should close files, but %d descriptors are left open", len(after)-len(fds))
+	}
+}
+
+func TestGannoyIndexWithMemoryStorage(t *testing.T) {
+	name := "test_gannoy_index_with_memory_storage"
+	CreateMeta(".", name, 2, 3, 4)
+	defer os.Remove(name + ".meta")
+	defer os.Remove(name + ".tree")
+	defer os.Remove(name + ".leaves")
+
+	gannoy, _ := NewGannoyIndex(name+".meta", Angular{}, RandRandom{})
+	gannoy.AddItem(10, []float64{1.1, 1.2, 1.3})
+
+	memory, err := NewGannoyIndexWithStorage(name+".meta", Angular{}, RandRandom{}, MEMORY)
+	if err != nil {
+		t.Errorf("NewGannoyIndexWithStorage with memory should not return error.")
+	}
+	if !memory.nodes.maps.isExist(10) {
+		t.Errorf("Memory storage should load nodes from tree file.")
+	}
+
+	before := gannoy.nodes.Storage.(*File).size()
+	memory.AddItem(20, []float64{-1.1, -1.2, -1.3})
+	if size := gannoy.nodes.Storage.(*File).size(); size != before {
+		t.Errorf("Memory storage should not write to tree file.")
+	}
+	meta, _ := loadMeta(name + ".meta")
+	if meta.roots()[0] != gannoy.meta.roots()[0] || memory.meta.roots()[0] == meta.roots()[0] {
+		t.Errorf("Memory storage should not write roots to meta file.")
+	}
+}
@@ -8,12 +8,52 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 )
 
+type MetaOptions struct {
+	Encoding   int        // encoding of vectors in tree file such as FLOAT64 or FLOAT32
+	Min        []float64  // min of each dimension for INT8 encoding
+	Max        []float64  // max of each dimension for INT8 encoding
+	Codebook   *Codebook  // codebook for PQ encoding
+	Metric     int        // metric of distance such as ANGULAR
+	Endian     int        // byte order of meta and tree files such as LITTLE_ENDIAN
+	Durability Durability // policy of syncing files (default: DURABILITY_NONE)
+}
+
 func CreateMeta(path, file string, tree, dim, K int) error {
+	return CreateMetaWithOptions(path, file, tree, dim, K, MetaOptions{Encoding: FLOAT64})
+}
+
+func CreateMetaWithOptions(path, file string, tree, dim, K int, opts MetaOptions) error {
+	switch opts.Encoding {
+	case INT8:
+		if _, err := newInt8Codec(dim, opts.Min, opts.Max); err != nil {
+			return err
+		}
+	case PQ:
+		if opts.Codebook == nil || opts.Codebook.dim != dim {
+			return fmt.Errorf("pq encoding requires codebook of dim %d.", dim)
+		}
+	default:
+		if _, err := newCodec(opts.Encoding, dim, binary.BigEndian); err != nil {
+			return err
+		}
+	}
+	if opts.Metric != ANGULAR {
+		return fmt.Errorf("Unknown metric: %d.", opts.Metric)
+	}
+	if opts.Endian != BIG_ENDIAN && opts.Endian != LITTLE_ENDIAN {
+		return fmt.Errorf("Unknown endian: %d.", opts.Endian)
+	}
+	durability, err := opts.Durability.validate()
+	if err != nil {
+		return err
+	}
 	database := filepath.Join(path, file+".meta")
-	_, err := os.Stat(database)
+	_, err = os.Stat(database)
 	if err == nil {
 		return fmt.Errorf("Already exist database: %s.", database)
 	}
@@ -24,80 +64,243 @@ func CreateMeta(path, file string, tree, dim, K int) error {
 	}
 	defer f.Close()
 
-	binary.Write(f, binary.BigEndian, int32(tree))
-	binary.Write(f, binary.BigEndian, int32(dim))
-	binary.Write(f, binary.BigEndian, int32(K))
+	h := newHeader(metaMagic, opts.Endian, opts.Metric)
+	h.durability = durability.Policy
+	h.syncInterval = int(durability.Interval / time.Millisecond)
+	f.Write(h.bytes())
+	binary.Write(f, h.order(), int32(tree))
+	binary.Write(f, h.order(), int32(dim))
+	binary.Write(f, h.order(), int32(K))
 	roots := make([]int32, tree)
 	for i, _ := range roots {
 		roots[i] = int32(-1)
 	}
-	binary.Write(f, binary.BigEndian, roots)
+	binary.Write(f, h.order(), roots)
+	binary.Write(f, h.order(), int32(opts.Encoding))
+	switch opts.Encoding {
+	case INT8:
+		binary.Write(f, h.order(), opts.Min)
+		binary.Write(f, h.order(), opts.Max)
+	case PQ:
+		binary.Write(f, h.order(), int32(opts.Codebook.M))
+		return opts.Codebook.save(filepath.Join(path, file+".pq"))
+	}
 
 	return nil
 }
 
 type meta struct {
-	path string
-	file *os.File
-	tree int
-	dim  int
-	K    int
+	header    header
+	path      string
+	file      *os.File
+	tree      int
+	dim       int
+	K         int
+	encoding  int
+	min       []float64
+	max       []float64
+	codebook  Codebook
+	rootStore rootStore // roots are kept here instead of meta file if not nil
+	readOnly  bool      // files are opened read-only and never locked
+}
+
+// rootStore is implemented by storages which keep roots with nodes.
+type rootStore interface {
+	roots() []int
+	updateRoot(int, int) error
+}
+
+type memoryRoots struct {
+	mu  sync.RWMutex
+	ids []int
+}
+
+func (r *memoryRoots) roots() []int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]int{}, r.ids...)
+}
+
+func (r *memoryRoots) updateRoot(index, root int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ids[index] = root
+	return nil
+}
+
+func newMemoryMeta(tree, dim, K int) meta {
+	roots := make([]int, tree)
+	for i, _ := range roots {
+		roots[i] = -1
+	}
+	return meta{
+		header:    newHeader(metaMagic, BIG_ENDIAN, ANGULAR),
+		tree:      tree,
+		dim:       dim,
+		K:         K,
+		encoding:  FLOAT64,
+		rootStore: &memoryRoots{ids: roots},
+	}
+}
+
+// inMemory returns meta which keeps roots in memory from now on.
+func (m meta) inMemory() meta {
+	return m.withRoots(&memoryRoots{ids: m.roots()})
+}
+
+// withRoots returns meta which reads and writes roots by store from now on.
+func (m meta) withRoots(store rootStore) meta {
+	m.rootStore = store
+	return m
+}
+
+// options returns options to create the same meta file.
+func (m meta) options() MetaOptions {
+	opts := MetaOptions{Encoding: m.encoding, Min: m.min, Max: m.max, Metric: m.header.metric, Endian: m.header.endian, Durability: m.durability()}
+	if m.encoding == PQ {
+		codebook := m.codebook
+		opts.Codebook = &codebook
+	}
+	return opts
 }
 
 func loadMeta(filename string) (meta, error) {
+	return openMeta(filename, false)
+}
+
+// openMeta loads meta file. If readOnly, files of the database are regarded as immutable.
+func openMeta(filename string, readOnly bool) (meta, error) {
 	_, err := os.Stat(filename)
 	if err != nil {
 		return meta{}, err
 	}
-	file, _ := os.OpenFile(filename, os.O_RDWR, 0)
+	flag := os.O_RDWR
+	if readOnly {
+		flag = os.O_RDONLY
+	}
+	file, err := os.OpenFile(filename, flag, 0)
+	if err != nil {
+		return meta{}, err
+	}
 
-	b := make([]byte, 4*3)
+	b := make([]byte, headerSize)
 	syscall.Pread(int(file.Fd()), b, 0)
+	h, err := parseHeader(metaMagic, b)
+	if err != nil {
+		file.Close()
+		return meta{}, err
+	}
+
+	b = make([]byte, 4*3)
+	syscall.Pread(int(file.Fd()), b, h.size())
 
 	buf := bytes.NewReader(b)
 	var tree, dim, K int32
-	binary.Read(buf, binary.BigEndian, &tree)
-	binary.Read(buf, binary.BigEndian, &dim)
-	binary.Read(buf, binary.BigEndian, &K)
+	binary.Read(buf, h.order(), &tree)
+	binary.Read(buf, h.order(), &dim)
+	binary.Read(buf, h.order(), &K)
 
-	return meta{
-		path: filename,
-		file: file,
-		tree: int(tree),
-		dim:  int(dim),
-		K:    int(K),
-	}, nil
+	m := meta{
+		header:   h,
+		path:     filename,
+		file:     file,
+		tree:     int(tree),
+		dim:      int(dim),
+		K:        int(K),
+		encoding: FLOAT64,
+		readOnly: readOnly,
+	}
+
+	// Meta files created before encoding was introduced end at roots.
+	b = make([]byte, 4)
+	if n, _ := syscall.Pread(int(file.Fd()), b, m.encodingOffset()); n == 4 {
+		m.encoding = int(int32(h.order().Uint32(b)))
+	}
+	switch m.encoding {
+	case INT8:
+		b = make([]byte, 8*m.dim*2)
+		syscall.Pread(int(file.Fd()), b, m.encodingOffset()+4)
+		m.min = bytesToFloat64s(h.order(), b[:8*m.dim])
+		m.max = bytesToFloat64s(h.order(), b[8*m.dim:])
+	case PQ:
+		syscall.Pread(int(file.Fd()), b, m.encodingOffset()+4)
+		M := int(int32(h.order().Uint32(b)))
+		codebook, err := loadCodebook(m.codebookPath())
+		if err != nil {
+			file.Close()
+			return meta{}, err
+		}
+		if codebook.M != M || codebook.dim != m.dim {
+			file.Close()
+			return meta{}, fmt.Errorf("Codebook mismatch. expect M %d and dim %d, but %d and %d.", M, m.dim, codebook.M, codebook.dim)
+		}
+		m.codebook = codebook
+	}
+	return m, nil
+}
+
+// close closes meta file. Meta in memory has no file.
+func (m meta) close() error {
+	if m.file == nil {
+		return nil
+	}
+	return m.file.Close()
+}
+
+// durability returns policy of syncing files recorded in meta file.
+func (m meta) durability() Durability {
+	return Durability{
+		Policy:   m.header.durability,
+		Interval: time.Duration(m.header.syncInterval) * time.Millisecond,
+	}
+}
+
+// sync flushes roots to disk.
+func (m meta) sync() error {
+	if m.file == nil {
+		return nil
+	}
+	return m.file.Sync()
+}
+
+func (m meta) encodingOffset() int64 {
+	return m.rootOffset(m.tree)
 }
 
 func (m meta) rootOffset(index int) int64 {
-	return int64(4 + // tree
-		4 + // dim
-		4 + // K
+	return m.header.size() + int64(4+ // tree
+		4+ // dim
+		4+ // K
 		4*index) // roots
 }
 
 func (m meta) roots() []int {
-	err := syscall.FcntlFlock(m.file.Fd(), syscall.F_SETLKW, &syscall.Flock_t{
-		Start:  m.rootOffset(0),
-		Len:    int64(m.tree * 4),
-		Type:   syscall.F_RDLCK,
-		Whence: io.SeekStart,
-	})
-	if err != nil {
-		return []int{}
+	if m.rootStore != nil {
+		return m.rootStore.roots()
+	}
+	if !m.readOnly {
+		err := syscall.FcntlFlock(m.file.Fd(), syscall.F_SETLKW, &syscall.Flock_t{
+			Start:  m.rootOffset(0),
+			Len:    int64(m.tree * 4),
+			Type:   syscall.F_RDLCK,
+			Whence: io.SeekStart,
+		})
+		if err != nil {
+			return []int{}
+		}
+		defer syscall.FcntlFlock(m.file.Fd(), syscall.F_SETLKW, &syscall.Flock_t{
+			Start:  m.rootOffset(0),
+			Len:    int64(m.tree * 4),
+			Type:   syscall.F_UNLCK,
+			Whence: io.SeekStart,
+		})
 	}
-	defer syscall.FcntlFlock(m.file.Fd(), syscall.F_SETLKW, &syscall.Flock_t{
-		Start:  m.rootOffset(0),
-		Len:    int64(m.tree * 4),
-		Type:   syscall.F_UNLCK,
-		Whence: io.SeekStart,
-	})
 
 	b := make([]byte, m.tree*4)
 	syscall.Pread(int(m.file.Fd()), b, m.rootOffset(0))
 	buf := bytes.NewReader(b)
 	roots := make([]int32, m.tree)
-	binary.Read(buf, binary.BigEndian, &roots)
+	binary.Read(buf, m.header.order(), &roots)
 	result := make([]int, m.tree)
 	for i, r := range roots {
 		result[i] = int(r)
@@ -106,6 +309,12 @@ func (m meta) roots() []int {
 }
 
 func (m meta) updateRoot(index, root int) error {
+	if m.rootStore != nil {
+		return m.rootStore.updateRoot(index, root)
+	}
+	if m.readOnly {
+		return ErrReadOnly
+	}
 	offset := m.rootOffset(index)
 	err := syscall.FcntlFlock(m.file.Fd(), syscall.F_SETLKW, &syscall.Flock_t{
 		Start:  offset,
@@ -123,7 +332,7 @@ func (m meta) updateRoot(index, root int) error {
 		Whence: io.SeekStart,
 	})
 	buf := &bytes.Buffer{}
-	binary.Write(buf, binary.BigEndian, int32(root))
+	binary.Write(buf, m.header.order(), int32(root))
 	_, err = syscall.Pwrite(int(m.file.Fd()), buf.Bytes(), offset)
 	if err != nil {
 		return err
@@ -132,10 +341,37 @@ func (m meta) updateRoot(index, root int) error {
 	return err
 }
 
+// treeHeader returns header of tree file in the same format as meta file.
+func (m meta) treeHeader() header {
+	h := m.header
+	h.magic = treeMagic
+	return h
+}
+
 func (m meta) treePath() string {
 	return m.filePath("tree")
 }
 
+func (m meta) vectorPath() string {
+	return m.filePath("vec")
+}
+
+func (m meta) boltPath() string {
+	return m.filePath("bolt")
+}
+
+func (m meta) changesPath() string {
+	return m.filePath("changes")
+}
+
+func (m meta) walPath() string {
+	return m.filePath("wal")
+}
+
+func (m meta) codebookPath() string {
+	return m.filePath("pq")
+}
+
 func (m meta) filePath(newExt string) string {
 	ext := filepath.Ext(m.path)
 	return fmt.Sprintf("%s.%s", strings.Split(m.path, ext)[0], newExt)
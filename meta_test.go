@@ -1,6 +1,7 @@
 package gannoy
 
 import (
+	"io/ioutil"
 	"os"
 	"testing"
 )
@@ -74,3 +75,121 @@ func TestUpdateRoot(t *testing.T) {
 		}
 	}
 }
+
+func TestLoadMetaEncoding(t *testing.T) {
+	file := "test_load_meta_encoding"
+
+	CreateMetaWithOptions(".", file, 2, 3, 4, MetaOptions{Encoding: FLOAT32})
+	defer os.Remove(file + ".meta")
+
+	meta, _ := loadMeta(file + ".meta")
+	if meta.encoding != FLOAT32 {
+		t.Errorf("encoding should be %d, but %d.", FLOAT32, meta.encoding)
+	}
+
+	// Meta file without encoding is loaded as float64.
+	os.Truncate(file+".meta", meta.encodingOffset())
+	meta, _ = loadMeta(file + ".meta")
+	if meta.encoding != FLOAT64 {
+		t.Errorf("encoding of old meta file should be %d, but %d.", FLOAT64, meta.encoding)
+	}
+}
+
+func TestCreateMetaUnknownEncoding(t *testing.T) {
+	file := "test_create_meta_unknown_encoding"
+	defer os.Remove(file + ".meta")
+
+	err := CreateMetaWithOptions(".", file, 2, 3, 4, MetaOptions{Encoding: -1})
+	if err == nil {
+		t.Errorf("CreateMeta with unknown encoding should return error.")
+	}
+}
+
+func TestLoadMetaInt8Encoding(t *testing.T) {
+	file := "test_load_meta_int8_encoding"
+	defer os.Remove(file + ".meta")
+
+	err := CreateMetaWithOptions(".", file, 2, 3, 4, MetaOptions{Encoding: INT8})
+	if err == nil {
+		t.Errorf("CreateMeta with int8 encoding without min and max should return error.")
+	}
+
+	min := []float64{-1.0, -2.0, -3.0}
+	max := []float64{1.0, 2.0, 3.0}
+	CreateMetaWithOptions(".", file, 2, 3, 4, MetaOptions{Encoding: INT8, Min: min, Max: max})
+
+	meta, _ := loadMeta(file + ".meta")
+	if meta.encoding != INT8 {
+		t.Errorf("encoding should be %d, but %d.", INT8, meta.encoding)
+	}
+	for i := 0; i < 3; i++ {
+		if meta.min[i] != min[i] || meta.max[i] != max[i] {
+			t.Errorf("min and max should be %v and %v, but %v and %v.", min, max, meta.min, meta.max)
+			break
+		}
+	}
+	if len(meta.roots()) != 2 {
+		t.Errorf("roots size should be %d, but %d.", 2, len(meta.roots()))
+	}
+}
+
+func TestLoadMetaHeader(t *testing.T) {
+	file := "test_load_meta_header"
+	CreateMeta(".", file, 2, 3, 4)
+	defer os.Remove(file + ".meta")
+
+	meta, _ := loadMeta(file + ".meta")
+	if meta.header.version != formatVersion || meta.header.metric != ANGULAR {
+		t.Errorf("Meta file should have header of version %d, but %v.", formatVersion, meta.header)
+	}
+
+	// Wrong file is not loaded silently.
+	ioutil.WriteFile(file+".meta", append(newHeader(metaMagic, BIG_ENDIAN, ANGULAR).bytes()[:4], 0, formatVersion+1), 0666)
+	if _, err := loadMeta(file + ".meta"); err == nil {
+		t.Errorf("LoadMeta of unsupported version should return error.")
+	}
+}
+
+func TestLoadMetaLegacy(t *testing.T) {
+	file := "test_load_meta_legacy"
+	createLegacyMeta(file, 2, 3, 4)
+	defer os.Remove(file + ".meta")
+
+	meta, err := loadMeta(file + ".meta")
+	if err != nil {
+		t.Errorf("LoadMeta of file without header should not return error, but %v", err)
+	}
+	if !meta.header.legacy() || meta.tree != 2 || meta.dim != 3 || meta.K != 4 {
+		t.Errorf("Meta file without header should be loaded as version 0, but %v.", meta)
+	}
+	meta.updateRoot(1, 10)
+	if roots := meta.roots(); roots[0] != -1 || roots[1] != 10 {
+		t.Errorf("Roots of meta file without header should be updated, but %v.", roots)
+	}
+}
+
+// createLegacyMeta creates meta file without header.
+func createLegacyMeta(file string, tree, dim, K int) {
+	CreateMeta(".", file, tree, dim, K)
+	b, _ := ioutil.ReadFile(file + ".meta")
+	ioutil.WriteFile(file+".meta", b[headerSize:], 0666)
+}
+
+func TestLoadMetaLittleEndian(t *testing.T) {
+	file := "test_load_meta_little_endian"
+	CreateMetaWithOptions(".", file, 2, 3, 4, MetaOptions{Endian: LITTLE_ENDIAN})
+	defer os.Remove(file + ".meta")
+
+	b, _ := ioutil.ReadFile(file + ".meta")
+	if b[headerSize] != 2 {
+		t.Errorf("Meta file of little endian should write tree in little endian, but %v.", b[headerSize:headerSize+4])
+	}
+	meta, _ := loadMeta(file + ".meta")
+	if meta.tree != 2 || meta.dim != 3 || meta.K != 4 || meta.header.endian != LITTLE_ENDIAN {
+		t.Errorf("Meta file of little endian should be loaded, but %v.", meta)
+	}
+	meta.updateRoot(1, 10)
+	if roots := meta.roots(); roots[0] != -1 || roots[1] != 10 {
+		t.Errorf("Roots of little endian should be updated, but %v.", roots)
+	}
+}
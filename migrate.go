@@ -0,0 +1,66 @@
+package gannoy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type MigrateOptions struct {
+	Endian int // byte order of migrated files such as LITTLE_ENDIAN
+}
+
+// Migrate rewrites meta and tree files of the database into the current format,
+// keeping byte order of them. Files in the current format are left as they are.
+func Migrate(metaFile string) error {
+	m, err := loadMeta(metaFile)
+	if err != nil {
+		return err
+	}
+	m.file.Close()
+	return MigrateWithOptions(metaFile, MigrateOptions{Endian: m.header.endian})
+}
+
+// MigrateWithOptions rewrites meta and tree files of the database into the current
+// format in byte order of opts. Files already in the format are left as they are.
+func MigrateWithOptions(metaFile string, opts MigrateOptions) error {
+	m, err := loadMeta(metaFile)
+	if err != nil {
+		return err
+	}
+	m.file.Close()
+	if m.header.version == formatVersion && m.header.endian == opts.Endian {
+		return nil
+	}
+
+	g, err := NewGannoyIndexWithOptions(metaFile, Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(metaFile)
+	name := strings.TrimSuffix(filepath.Base(metaFile), ".meta")
+	tmp := name + ".migrating"
+	for _, ext := range []string{".meta", ".tree", ".leaves", ".vec", ".pq"} {
+		os.Remove(filepath.Join(dir, tmp+ext))
+	}
+	options := g.meta.options()
+	options.Endian = opts.Endian
+	err = g.snapshot(dir, tmp, options)
+	// Files of the source are closed before they are replaced.
+	g.close()
+	if err != nil {
+		return err
+	}
+
+	// Meta file is replaced last, so that it is read with the new tree file.
+	for _, ext := range []string{".tree", ".leaves", ".vec", ".pq", ".meta"} {
+		src := filepath.Join(dir, tmp+ext)
+		if _, err := os.Stat(src); err != nil {
+			continue
+		}
+		if err := os.Rename(src, filepath.Join(dir, name+ext)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
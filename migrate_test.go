@@ -0,0 +1,118 @@
+package gannoy
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	name := "test_migrate"
+	createLegacyMeta(name, 2, 3, 4)
+	defer os.Remove(name + ".meta")
+	defer os.Remove(name + ".tree")
+	defer os.Remove(name + ".leaves")
+
+	if _, err := NewGannoyIndex(name+".meta", Angular{}, RandRandom{}); err == nil {
+		t.Errorf("Database without header should not be opened for writes before migration.")
+	}
+	legacy := openLegacyIndex(name + ".meta")
+	items := [][]float64{
+		{1.1, 1.2, 1.3},
+		{-1.1, -1.2, -1.3},
+		{1.1, 1.2, 1.3},
+		{-1.1, -1.2, -1.3},
+		{-1.1, -1.2, -1.3},
+	}
+	for i, item := range items {
+		legacy.AddItem(i*10, item)
+	}
+	expect, _ := legacy.GetNnsByKey(40, 3, -1)
+
+	if _, err := NewGannoyIndexWithOptions(name+".meta", Options{ReadOnly: true}); err != nil {
+		t.Errorf("Database without header should be opened read-only, but %v", err)
+	}
+	if err := Migrate(name + ".meta"); err != nil {
+		t.Errorf("Migrate should not return error, but %v", err)
+	}
+	if _, err := os.Stat(name + ".migrating.meta"); err == nil {
+		t.Errorf("Migrate should not leave temporary files.")
+	}
+
+	migrated, err := NewGannoyIndex(name+".meta", Angular{}, RandRandom{})
+	if err != nil {
+		t.Errorf("Migrated database should be loaded, but %v", err)
+	}
+	if migrated.meta.header.version != formatVersion {
+		t.Errorf("Migrate should write header of version %d, but %d", formatVersion, migrated.meta.header.version)
+	}
+	if file := migrated.nodes.Storage.(*File); !file.checksum {
+		t.Errorf("Migrate should write tree file with checksum.")
+	}
+	nns, _ := migrated.GetNnsByKey(40, 3, -1)
+	for i, key := range expect {
+		if nns[i] != key {
+			t.Errorf("Migrated database should return %v, but %v", expect, nns)
+			break
+		}
+	}
+
+	// Database in the current format is left as it is.
+	if err := Migrate(name + ".meta"); err != nil {
+		t.Errorf("Migrate of current format should not return error, but %v", err)
+	}
+}
+
+func TestMigrateEndian(t *testing.T) {
+	name := "test_migrate_endian"
+	CreateMeta(".", name, 2, 3, 4)
+	defer os.Remove(name + ".meta")
+	defer os.Remove(name + ".tree")
+	defer os.Remove(name + ".leaves")
+
+	gannoy, _ := NewGannoyIndex(name+".meta", Angular{}, &TestLoopRandom{max: 1})
+	items := [][]float64{
+		{1.1, 1.2, 1.3},
+		{-1.1, -1.2, -1.3},
+		{1.1, 1.2, 1.3},
+		{-1.1, -1.2, -1.3},
+		{-1.1, -1.2, -1.3},
+	}
+	for i, item := range items {
+		gannoy.AddItem(i*10, item)
+	}
+	expect, _ := gannoy.GetNnsByKey(40, 3, -1)
+
+	for _, endian := range []int{LITTLE_ENDIAN, BIG_ENDIAN} {
+		if err := MigrateWithOptions(name+".meta", MigrateOptions{Endian: endian}); err != nil {
+			t.Errorf("MigrateWithOptions should not return error, but %v", err)
+		}
+		migrated, err := NewGannoyIndex(name+".meta", Angular{}, RandRandom{})
+		if err != nil {
+			t.Errorf("Migrated database should be loaded, but %v", err)
+			continue
+		}
+		file := migrated.nodes.Storage.(*File)
+		if migrated.meta.header.endian != endian || file.header.endian != endian {
+			t.Errorf("MigrateWithOptions should write files in endian %d, but %d and %d", endian, migrated.meta.header.endian, file.header.endian)
+		}
+		nns, _ := migrated.GetNnsByKey(40, 3, -1)
+		for i, key := range expect {
+			if nns[i] != key {
+				t.Errorf("Migrated database should return %v, but %v", expect, nns)
+				break
+			}
+		}
+		key := 50 + endian
+		migrated.AddItem(key, []float64{1.1, 1.2, 1.3})
+		if nns, _ := migrated.GetNnsByKey(key, 1, -1); len(nns) != 1 {
+			t.Errorf("Migrated database should be updated.")
+		}
+	}
+}
+
+// openLegacyIndex opens database without header for writes, which NewGannoyIndex rejects.
+func openLegacyIndex(metaFile string) GannoyIndex {
+	m, _ := loadMeta(metaFile)
+	file, _ := newFileFromMeta(m)
+	return newGannoyIndex(m, file, nil, nil, Options{Random: &TestLoopRandom{max: 1}})
+}
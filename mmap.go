@@ -0,0 +1,189 @@
+package gannoy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// MmapFile reads nodes from the tree and leaves files mapped into memory.
+// Writes are delegated to File. Readers and writers are coordinated by
+// mu, and a region is remapped when a node beyond it is read after
+// the file grows.
+type MmapFile struct {
+	*File
+	mu        sync.RWMutex
+	treeMap   mapping
+	leavesMap mapping
+}
+
+// mapping is a region of the whole file mapped into memory.
+type mapping struct {
+	file *os.File
+	data []byte
+}
+
+var errOutOfFile = errors.New("out of file")
+
+func newMmapFile(file *File) (*MmapFile, error) {
+	m := &MmapFile{File: file, treeMap: mapping{file: file.file}}
+	if file.leaves != nil {
+		m.leavesMap = mapping{file: file.leaves.file}
+	}
+	if err := m.treeMap.remap(); err != nil {
+		return nil, err
+	}
+	if err := m.leavesMap.remap(); err != nil {
+		m.treeMap.unmap()
+		return nil, err
+	}
+	return m, nil
+}
+
+// remap maps the file by its current size.
+// It must be called with write lock except in constructor.
+func (r *mapping) remap() error {
+	if r.file == nil {
+		return nil
+	}
+	if err := r.unmap(); err != nil {
+		return err
+	}
+	info, err := r.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return nil
+	}
+	data, err := syscall.Mmap(int(r.file.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+	r.data = data
+	return nil
+}
+
+func (r *mapping) unmap() error {
+	if r.data == nil {
+		return nil
+	}
+	err := syscall.Munmap(r.data)
+	r.data = nil
+	return err
+}
+
+// read calls fn with the mapped bytes between offset and end, remapping
+// the region if the file has grown. b must not be used after fn returns.
+func (m *MmapFile) read(r *mapping, offset, end int64, fn func(b []byte) error) error {
+	m.mu.RLock()
+	if end > int64(len(r.data)) {
+		m.mu.RUnlock()
+		m.mu.Lock()
+		if end > int64(len(r.data)) {
+			if err := r.remap(); err != nil {
+				m.mu.Unlock()
+				return err
+			}
+		}
+		m.mu.Unlock()
+		m.mu.RLock()
+	}
+	defer m.mu.RUnlock()
+
+	if end > int64(len(r.data)) {
+		return errOutOfFile
+	}
+	return fn(r.data[offset:end])
+}
+
+func (m *MmapFile) Find(id int) (Node, error) {
+	node := Node{id: id, storage: m}
+	if id < 0 {
+		return node, fmt.Errorf("Node %d is out of file.", id)
+	}
+	var err error
+	if m.isLeafId(id) {
+		slot := leafSlot(id)
+		offset := m.leaves.offset(slot)
+		err = m.read(&m.leavesMap, offset, offset+m.leaves.recordSize(), func(b []byte) error {
+			if err := m.leaves.verify(slot, b); err != nil {
+				return err
+			}
+			node = m.leaves.decode(b)
+			node.id = id
+			node.storage = m
+			return nil
+		})
+	} else {
+		offset := m.offset(id)
+		err = m.read(&m.treeMap, offset, offset+m.nodeSize, func(b []byte) error {
+			var err error
+			node, err = m.decode(node, b)
+			return err
+		})
+	}
+	if err == errOutOfFile {
+		return node, fmt.Errorf("Node %d is out of file.", id)
+	}
+	return node, err
+}
+
+// findCode reads PQ codes of the leaf from mapped leaves file.
+func (m *MmapFile) findCode(id int) (int, []byte, error) {
+	if !m.isLeafId(id) {
+		return m.File.findCode(id)
+	}
+	slot := leafSlot(id)
+	offset := m.leaves.offset(slot)
+	key := -1
+	var code []byte
+	err := m.read(&m.leavesMap, offset, offset+m.leaves.recordSize(), func(b []byte) error {
+		if err := m.leaves.verify(slot, b); err != nil {
+			return err
+		}
+		k, c, _ := m.leaves.recordCode(b)
+		key, code = k, append([]byte{}, c...)
+		return nil
+	})
+	if err == errOutOfFile {
+		return key, nil, fmt.Errorf("Leaf %d is out of file.", slot)
+	}
+	return key, code, err
+}
+
+func (m *MmapFile) Update(n Node) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.File.Update(n)
+}
+
+func (m *MmapFile) UpdateParent(id, rootIndex, parent int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.File.UpdateParent(id, rootIndex, parent)
+}
+
+func (m *MmapFile) Delete(n Node) error {
+	n.free = true
+	return m.Update(n)
+}
+
+func (m *MmapFile) Iterate(c chan Node) {
+	iterate(m, m.ids(), c)
+}
+
+// Close unmaps the regions and closes the file.
+func (m *MmapFile) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.treeMap.unmap(); err != nil {
+		return err
+	}
+	if err := m.leavesMap.unmap(); err != nil {
+		return err
+	}
+	return m.File.Close()
+}
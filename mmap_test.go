@@ -0,0 +1,149 @@
+package gannoy
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMmapFileFindAfterCreate(t *testing.T) {
+	name := "test_mmap_file_find_after_create.tree"
+	defer os.Remove(name)
+	defer os.Remove(leavesPath(name))
+	f, _ := newFile(name, 2, 3, 4)
+	file, err := newMmapFile(f)
+	if err != nil {
+		t.Errorf("newMmapFile should not return error.")
+	}
+	defer file.Close()
+
+	// Not found in empty file
+	_, err = file.Find(0)
+	if err == nil {
+		t.Errorf("MmapFile Find for out of file should return error.")
+	}
+
+	// Remap as file grows
+	for i := 0; i < 3; i++ {
+		node := Node{
+			key:          i * 10,
+			nDescendants: 1,
+			parents:      []int{2, 3},
+			v:            []float64{1.1, 1.2, float64(i)},
+		}
+		id, _ := file.Create(node)
+		found, err := file.Find(id)
+		if err != nil {
+			t.Errorf("MmapFile Find after create should not return error.")
+		}
+		if found.key != node.key || found.v[2] != node.v[2] {
+			t.Errorf("MmapFile Find should return created node %v, but %v", node, found)
+		}
+		if found.storage != file {
+			t.Errorf("MmapFile Find should return node bound to MmapFile.")
+		}
+	}
+}
+
+func TestMmapFileUpdate(t *testing.T) {
+	name := "test_mmap_file_update.tree"
+	defer os.Remove(name)
+	defer os.Remove(leavesPath(name))
+	f, _ := newFile(name, 2, 3, 4)
+	file, _ := newMmapFile(f)
+	defer file.Close()
+
+	node := Node{
+		key:          10,
+		nDescendants: 1,
+		parents:      []int{2, 3},
+		v:            []float64{1.1, 1.2, 1.3},
+	}
+	id, _ := file.Create(node)
+	found, _ := file.Find(id)
+
+	// Update
+	found.v = []float64{2.1, 2.2, 2.3}
+	if err := file.Update(found); err != nil {
+		t.Errorf("MmapFile update should not return error.")
+	}
+	updated, _ := file.Find(id)
+	for i, v := range updated.v {
+		if v != found.v[i] {
+			t.Errorf("MmapFile update should be visible in mapped region with v %v, but %v", found.v, updated.v)
+		}
+	}
+
+	// Update parent
+	file.UpdateParent(id, 1, 30)
+	updated, _ = file.Find(id)
+	if updated.parents[1] != 30 {
+		t.Errorf("MmapFile update parent should be visible in mapped region, but %v", updated.parents)
+	}
+
+	// Delete
+	file.Delete(updated)
+	deleted, _ := file.Find(id)
+	if !deleted.free {
+		t.Errorf("MmapFile delete should mark node free.")
+	}
+}
+
+func TestGannoyIndexWithMmapStorage(t *testing.T) {
+	name := "test_gannoy_index_with_mmap_storage"
+	CreateMeta(".", name, 2, 3, 4)
+	defer os.Remove(name + ".meta")
+	defer os.Remove(name + ".tree")
+	defer os.Remove(name + ".leaves")
+
+	gannoy, err := NewGannoyIndexWithStorage(name+".meta", Angular{}, &TestLoopRandom{max: 1}, MMAP)
+	if err != nil {
+		t.Errorf("NewGannoyIndexWithStorage with mmap should not return error.")
+	}
+	items := [][]float64{
+		{1.1, 1.2, 1.3},
+		{-1.1, -1.2, -1.3},
+		{1.1, 1.2, 1.3},
+		{-1.1, -1.2, -1.3},
+		{-1.1, -1.2, -1.3},
+	}
+	for i, item := range items {
+		gannoy.AddItem(i*10, item)
+	}
+
+	nns, err := gannoy.GetNnsByKey(40, 3, -1)
+	if err != nil || len(nns) != 3 {
+		t.Errorf("GannoyIndex GetNnsByKey with mmap storage should return specified size list, but %v", nns)
+	}
+
+	_, err = NewGannoyIndexWithStorage(name+".meta", Angular{}, RandRandom{}, -1)
+	if err == nil {
+		t.Errorf("NewGannoyIndexWithStorage with unknown storage should return error.")
+	}
+}
+
+func TestMmapFileFindOutOfFile(t *testing.T) {
+	name := "test_mmap_file_find_out_of_file.tree"
+	defer os.Remove(name)
+	defer os.Remove(leavesPath(name))
+	f, _ := newFile(name, 2, 3, 4)
+	file, _ := newMmapFile(f)
+	defer file.Close()
+
+	for i := 0; i < 100; i++ {
+		file.Create(Node{key: i, nDescendants: 1, parents: []int{2, 3}, v: []float64{1.1, 1.2, float64(i)}})
+		file.Create(Node{nDescendants: 2, parents: []int{2, 3}, children: []int{5, 6}, v: []float64{1.1, 1.2, 1.3}})
+	}
+
+	// Ids past end of files are not read from mapped region beyond them.
+	for _, id := range []int{100, 158, 1000, leafId(100), leafId(158), -1} {
+		if _, err := file.Find(id); err == nil {
+			t.Errorf("MmapFile Find for out of file should return error, but not for %d", id)
+		}
+	}
+	if _, err := file.Find(leafId(99)); err != nil {
+		t.Errorf("MmapFile Find for leaf should not return error, but %v", err)
+	}
+	if _, err := file.Find(99); err != nil {
+		t.Errorf("MmapFile Find for node should not return error, but %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package gannoy
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Neighbor is a key of an item and its distance from a query.
+type Neighbor struct {
+	Key      int     `json:"key"`
+	Distance float64 `json:"distance"`
+}
+
+// GetItem returns the vector of the key.
+func (g *GannoyIndex) GetItem(key int) ([]float64, error) {
+	node, err := g.nodes.getNodeByKey(key)
+	if err != nil || !node.isLeaf() {
+		return []float64{}, fmt.Errorf("Not found")
+	}
+	return g.vector(node)
+}
+
+// Neighbors returns distances between v and items of keys such as results of GetAllNns.
+// Keys removed in the meantime are skipped.
+func (g *GannoyIndex) Neighbors(v []float64, keys []int) ([]Neighbor, error) {
+	neighbors := make([]Neighbor, 0, len(keys))
+	for _, key := range keys {
+		w, err := g.GetItem(key)
+		if err != nil {
+			continue
+		}
+		neighbors = append(neighbors, Neighbor{Key: key, Distance: g.distance.distance(v, w)})
+	}
+	return neighbors, nil
+}
+
+// MergeNeighbors merges neighbors searched in each shard,
+// and returns n nearest keys.
+func MergeNeighbors(shards [][]Neighbor, n int) []int {
+	all := []Neighbor{}
+	for _, neighbors := range shards {
+		all = append(all, neighbors...)
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].Distance < all[j].Distance })
+
+	keys := []int{}
+	seen := map[int]bool{}
+	for _, neighbor := range all {
+		if len(keys) >= n {
+			break
+		}
+		if seen[neighbor.Key] {
+			continue
+		}
+		seen[neighbor.Key] = true
+		keys = append(keys, neighbor.Key)
+	}
+	return keys
+}
@@ -0,0 +1,52 @@
+package gannoy
+
+import (
+	"os"
+	"testing"
+)
+
+func TestGannoyIndexNeighbors(t *testing.T) {
+	name := "test_gannoy_index_neighbors"
+	CreateMeta(".", name, 2, 3, 4)
+	defer os.Remove(name + ".meta")
+	defer os.Remove(name + ".tree")
+	defer os.Remove(name + ".leaves")
+
+	gannoy, _ := NewGannoyIndex(name+".meta", Angular{}, &TestLoopRandom{max: 1})
+	gannoy.AddItem(0, []float64{1.0, 0.0, 0.0})
+	gannoy.AddItem(10, []float64{0.0, 1.0, 0.0})
+
+	if v, err := gannoy.GetItem(10); err != nil || v[1] != 1.0 {
+		t.Errorf("GannoyIndex GetItem should return vector of the key, but %v (%v)", v, err)
+	}
+	if _, err := gannoy.GetItem(20); err == nil {
+		t.Errorf("GannoyIndex GetItem should return error if key is not found.")
+	}
+
+	neighbors, _ := gannoy.Neighbors([]float64{1.0, 0.0, 0.0}, []int{0, 10, 20})
+	if len(neighbors) != 2 || neighbors[0].Key != 0 || neighbors[0].Distance != 0.0 || neighbors[1].Distance != 2.0 {
+		t.Errorf("GannoyIndex Neighbors should return distances of existing keys, but %v", neighbors)
+	}
+}
+
+func TestMergeNeighbors(t *testing.T) {
+	shards := [][]Neighbor{
+		{{Key: 1, Distance: 0.1}, {Key: 3, Distance: 0.5}},
+		{{Key: 2, Distance: 0.2}, {Key: 1, Distance: 0.1}},
+		{},
+	}
+	expect := []int{1, 2, 3}
+	keys := MergeNeighbors(shards, 5)
+	if len(keys) != len(expect) {
+		t.Fatalf("MergeNeighbors should return %v, but %v", expect, keys)
+	}
+	for i, key := range expect {
+		if keys[i] != key {
+			t.Errorf("MergeNeighbors should return %v, but %v", expect, keys)
+			break
+		}
+	}
+	if keys := MergeNeighbors(shards, 1); len(keys) != 1 || keys[0] != 1 {
+		t.Errorf("MergeNeighbors should return nearest %d keys, but %v", 1, keys)
+	}
+}
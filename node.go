@@ -2,14 +2,21 @@ package gannoy
 
 type Nodes struct {
 	Storage
-	free Free
+	free *Free // shared by copies of index
 	maps Maps
 }
 
-func newNodes(filename string, tree, dim, K int) Nodes {
-	// TODO Switch storage by parameter
+func newNodes(filename string, tree, dim, K int) (Nodes, error) {
+	file, err := newFile(filename, tree, dim, K)
+	if err != nil {
+		return Nodes{}, err
+	}
+	return newNodesWithStorage(file), nil
+}
+
+func newNodesWithStorage(storage Storage) Nodes {
 	nodes := Nodes{
-		Storage: newFile(filename, tree, dim, K),
+		Storage: storage,
 	}
 	// initialize free and maps
 	nodes.initialize()
@@ -19,7 +26,20 @@ func newNodes(filename string, tree, dim, K int) Nodes {
 func (n *Nodes) initialize() {
 	n.free = newFree()
 	n.maps = newMaps()
+	n.load()
+}
+
+// reload rebuilds free and maps in place, e.g. after a mutation is rolled back.
+func (n *Nodes) reload() {
+	if cache, ok := n.Storage.(*CacheStorage); ok {
+		cache.purge()
+	}
+	n.free.clear()
+	n.maps.clear()
+	n.load()
+}
 
+func (n *Nodes) load() {
 	iterator := make(chan Node)
 	go n.Iterate(iterator)
 
@@ -34,7 +54,17 @@ func (n *Nodes) initialize() {
 	}
 }
 
+// newNode returns a leaf, which reuses the id of removed leaf.
 func (ns *Nodes) newNode() Node {
+	return ns.newNodeOf(true)
+}
+
+// newInnerNode returns a bucket or split node, which reuses the id of removed one.
+func (ns *Nodes) newInnerNode() Node {
+	return ns.newNodeOf(false)
+}
+
+func (ns *Nodes) newNodeOf(leaf bool) Node {
 	node := Node{
 		storage: ns.Storage,
 
@@ -48,15 +78,24 @@ func (ns *Nodes) newNode() Node {
 
 		isNewRecord: true,
 	}
-	if free, err := ns.free.pop(); err == nil {
+	// Storages keeping leaves apart can not reuse ids across kinds.
+	kind := func(int) bool { return true }
+	if s, ok := ns.backend().(leafIds); ok {
+		kind = func(id int) bool { return s.isLeafId(id) == leaf }
+	}
+	if free, err := ns.free.popIf(kind); err == nil {
 		node.id = free
 		node.isNewRecord = false
 	}
 	return node
 }
 
-func (ns Nodes) getNode(id int) (Node, error) {
-	return ns.Storage.Find(id)
+func (ns *Nodes) getNode(id int) (Node, error) {
+	node, err := ns.Storage.Find(id)
+	// Storages outside this package can not bind themselves.
+	node.id = id
+	node.storage = ns.Storage
+	return node, err
 }
 
 func (ns *Nodes) getNodeByKey(key int) (Node, error) {
@@ -80,6 +119,45 @@ type Node struct {
 	isNewRecord  bool
 }
 
+// NodeData is the content of Node to be stored.
+// Storage implementations outside this package read it by Node.Data
+// and restore Node by NewNode.
+type NodeData struct {
+	NDescendants int
+	Key          int
+	Parents      []int
+	Children     []int
+	V            []float64
+	Free         bool
+}
+
+func NewNode(id int, d NodeData) Node {
+	return Node{
+		nDescendants: d.NDescendants,
+		id:           id,
+		key:          d.Key,
+		parents:      d.Parents,
+		children:     d.Children,
+		v:            d.V,
+		free:         d.Free,
+	}
+}
+
+func (n Node) Id() int {
+	return n.id
+}
+
+func (n Node) Data() NodeData {
+	return NodeData{
+		NDescendants: n.nDescendants,
+		Key:          n.key,
+		Parents:      n.parents,
+		Children:     n.children,
+		V:            n.v,
+		Free:         n.free,
+	}
+}
+
 func (n Node) isLeaf() bool {
 	return n.nDescendants == 1
 }
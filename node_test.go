@@ -8,7 +8,8 @@ import (
 func TestNewNodeAtFirst(t *testing.T) {
 	name := "test_new_node_at_first.tree"
 	defer os.Remove(name)
-	nodes := newNodes(name, 2, 3, 4)
+	defer os.Remove(leavesPath(name))
+	nodes, _ := newNodes(name, 2, 3, 4)
 
 	if len(nodes.free.free) != 0 {
 		t.Errorf("Initialized nodes.free size should be 0, but %d", len(nodes.free.free))
@@ -21,7 +22,8 @@ func TestNewNodeAtFirst(t *testing.T) {
 func TestNewNodeMpas(t *testing.T) {
 	name := "test_new_node_maps.tree"
 	defer os.Remove(name)
-	nodes := newNodes(name, 2, 3, 4)
+	defer os.Remove(leavesPath(name))
+	nodes, _ := newNodes(name, 2, 3, 4)
 
 	// Create
 	node := nodes.newNode()
@@ -30,7 +32,7 @@ func TestNewNodeMpas(t *testing.T) {
 	node.v = []float64{1.1, 1.2, 1.3}
 	node.save()
 
-	nodes = newNodes(name, 2, 3, 4)
+	nodes, _ = newNodes(name, 2, 3, 4)
 	id, err := nodes.maps.getId(10)
 	if err != nil {
 		t.Errorf("nodes.maps should not return error.")
@@ -43,7 +45,8 @@ func TestNewNodeMpas(t *testing.T) {
 func TestNewNodeFree(t *testing.T) {
 	name := "test_new_node_free.tree"
 	defer os.Remove(name)
-	nodes := newNodes(name, 2, 3, 4)
+	defer os.Remove(leavesPath(name))
+	nodes, _ := newNodes(name, 2, 3, 4)
 
 	// Create
 	node := nodes.newNode()
@@ -55,7 +58,7 @@ func TestNewNodeFree(t *testing.T) {
 	node, _ = nodes.getNode(node.id)
 	node.destroy()
 
-	nodes = newNodes(name, 2, 3, 4)
+	nodes, _ = newNodes(name, 2, 3, 4)
 	newNode := nodes.newNode() // from free node list.
 	if node.id != newNode.id {
 		t.Errorf("nodes.free should contain free node: %d, but %d", newNode.id, node.id)
@@ -65,7 +68,8 @@ func TestNewNodeFree(t *testing.T) {
 func TestNodeSaveNew(t *testing.T) {
 	name := "test_node_save_new.tree"
 	defer os.Remove(name)
-	nodes := newNodes(name, 2, 3, 4)
+	defer os.Remove(leavesPath(name))
+	nodes, _ := newNodes(name, 2, 3, 4)
 
 	// Create
 	node := nodes.newNode()
@@ -88,7 +92,8 @@ func TestNodeSaveNew(t *testing.T) {
 func TestNodeSaveUpdate(t *testing.T) {
 	name := "test_node_save_update.tree"
 	defer os.Remove(name)
-	nodes := newNodes(name, 2, 3, 4)
+	defer os.Remove(leavesPath(name))
+	nodes, _ := newNodes(name, 2, 3, 4)
 
 	// Create
 	node := nodes.newNode()
@@ -108,7 +113,8 @@ func TestNodeSaveUpdate(t *testing.T) {
 func TestNodeDestroy(t *testing.T) {
 	name := "test_node_destroy.tree"
 	defer os.Remove(name)
-	nodes := newNodes(name, 2, 3, 4)
+	defer os.Remove(leavesPath(name))
+	nodes, _ := newNodes(name, 2, 3, 4)
 
 	// Create
 	node := nodes.newNode()
@@ -0,0 +1,157 @@
+package gannoy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrReadOnly is returned by write operations of a read-only index.
+var ErrReadOnly = errors.New("Database is read-only.")
+
+// Options configures NewGannoyIndexWithOptions.
+// Zero values fall back to the defaults of NewGannoyIndex.
+type Options struct {
+	Storage    StorageFactory // opens nodes of the database (default: tree file)
+	Distance   Distance       // default: Angular
+	Random     Random         // default: RandRandom
+	NumWorker  int            // number of goroutines per search/build (default: min(tree, GOMAXPROCS))
+	ReadOnly   bool           // open files read-only without locks, and reject writes by ErrReadOnly
+	CacheSize  int            // number of nodes cached in front of storage (0 disables)
+	ChangeLog  bool           // record applied mutations with sequence numbers
+	Writers    int            // number of goroutines applying writes concurrently (default: 1, in order of writes)
+	WAL        bool           // queue asynchronous writes in write-ahead log
+	Durability *Durability    // overrides durability of meta file
+}
+
+// StorageConfig describes the database opened by a StorageFactory.
+type StorageConfig struct {
+	MetaFile string
+	Tree     int
+	Dim      int
+	K        int
+	ReadOnly bool
+
+	meta meta
+}
+
+// StorageFactory opens Storage for a database.
+// Storage implementations outside this package can exchange node contents
+// by Node.Data and NewNode.
+type StorageFactory func(StorageConfig) (Storage, error)
+
+// StorageFactoryOf returns factory of built-in storage such as FILE or MMAP.
+func StorageFactoryOf(storage int) StorageFactory {
+	return func(c StorageConfig) (Storage, error) {
+		return newStorage(c, storage)
+	}
+}
+
+func NewGannoyIndexWithOptions(metaFile string, opts Options) (GannoyIndex, error) {
+	meta, err := openMeta(metaFile, opts.ReadOnly)
+	if err != nil {
+		return GannoyIndex{}, err
+	}
+	// Files opened so far are closed on error.
+	var storage Storage
+	var changes *changeLog
+	var queue *writeQueue
+	fail := func(err error) (GannoyIndex, error) {
+		if queue != nil {
+			queue.log.close()
+		}
+		if changes != nil {
+			changes.close()
+		}
+		if closer, ok := storage.(io.Closer); ok {
+			closer.Close()
+		}
+		meta.close()
+		return GannoyIndex{}, err
+	}
+
+	if meta.header.legacy() && !opts.ReadOnly {
+		// Files without header are read only to be migrated.
+		return fail(fmt.Errorf("Database has no file header. Migrate it by gannoy migrate."))
+	}
+	if opts.Storage == nil {
+		opts.Storage = StorageFactoryOf(FILE)
+	}
+	if opts.Distance != nil {
+		metric, err := metricOf(opts.Distance)
+		if err != nil {
+			return fail(err)
+		}
+		if metric != meta.header.metric {
+			return fail(fmt.Errorf("Metric mismatch. expect %d, but %d.", meta.header.metric, metric))
+		}
+	}
+	if opts.CacheSize < 0 {
+		return fail(fmt.Errorf("Invalid cache size: %d.", opts.CacheSize))
+	}
+	if opts.Durability != nil {
+		durability, err := opts.Durability.validate()
+		if err != nil {
+			return fail(err)
+		}
+		opts.Durability = &durability
+	}
+
+	opened, err := opts.Storage(StorageConfig{
+		MetaFile: metaFile,
+		Tree:     meta.tree,
+		Dim:      meta.dim,
+		K:        meta.K,
+		ReadOnly: opts.ReadOnly,
+		meta:     meta,
+	})
+	if err != nil {
+		return fail(err)
+	}
+	storage = opened
+	if store, ok := storage.(rootStore); ok {
+		meta = meta.withRoots(store)
+	} else if _, ok := storage.(*MemoryStorage); ok {
+		// Roots follow nodes, which are never written back.
+		meta = meta.inMemory()
+	}
+	nodes := storage
+	if opts.CacheSize > 0 {
+		nodes = newCacheStorage(storage, opts.CacheSize)
+	}
+	if opts.ChangeLog && !opts.ReadOnly {
+		// Change log is big-endian like other side files, so that it survives migration.
+		h := newHeader(changesMagic, BIG_ENDIAN, meta.header.metric)
+		if changes, err = openChangeLog(meta.changesPath(), meta.dim, h); err != nil {
+			return fail(err)
+		}
+	}
+	var left []*queuedWrite
+	if opts.WAL && !opts.ReadOnly {
+		h := newHeader(walMagic, BIG_ENDIAN, meta.header.metric)
+		if queue, left, err = openWriteQueue(meta.walPath(), meta.dim, h); err != nil {
+			return fail(err)
+		}
+	}
+	index := newGannoyIndex(meta, nodes, changes, queue, opts)
+	if queue != nil {
+		index.replayQueue(left)
+	}
+	return index, nil
+}
+
+func (opts Options) withDefaults(tree int) Options {
+	if opts.Distance == nil {
+		opts.Distance = Angular{}
+	}
+	if opts.Random == nil {
+		opts.Random = RandRandom{}
+	}
+	if opts.NumWorker <= 0 {
+		opts.NumWorker = numWorker(tree)
+	}
+	if opts.Writers <= 0 {
+		opts.Writers = 1
+	}
+	return opts
+}
@@ -0,0 +1,200 @@
+package gannoy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+// mapStorage uses only exported API like storages outside this package.
+type mapStorage struct {
+	mu    sync.Mutex
+	nodes map[int]NodeData
+}
+
+func (s *mapStorage) Create(n Node) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := len(s.nodes)
+	s.nodes[id] = n.Data()
+	return id, nil
+}
+
+func (s *mapStorage) Find(id int) (Node, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.nodes[id]
+	if !ok {
+		return Node{}, fmt.Errorf("Not found")
+	}
+	return NewNode(id, d), nil
+}
+
+func (s *mapStorage) Update(n Node) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodes[n.Id()] = n.Data()
+	return nil
+}
+
+func (s *mapStorage) UpdateParent(id, rootIndex, parent int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodes[id].Parents[rootIndex] = parent
+	return nil
+}
+
+func (s *mapStorage) Delete(n Node) error {
+	d := n.Data()
+	d.Free = true
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodes[n.Id()] = d
+	return nil
+}
+
+func (s *mapStorage) Iterate(c chan Node) {
+	for id := 0; ; id++ {
+		n, err := s.Find(id)
+		if err != nil {
+			break
+		}
+		c <- n
+	}
+	close(c)
+}
+
+func TestGannoyIndexWithOptions(t *testing.T) {
+	name := "test_gannoy_index_with_options"
+	CreateMeta(".", name, 2, 3, 4)
+	defer os.Remove(name + ".meta")
+
+	storage := &mapStorage{nodes: map[int]NodeData{}}
+	var config StorageConfig
+	gannoy, err := NewGannoyIndexWithOptions(name+".meta", Options{
+		Storage: func(c StorageConfig) (Storage, error) {
+			config = c
+			return storage, nil
+		},
+		Random:    &TestLoopRandom{max: 1},
+		NumWorker: 1,
+	})
+	if err != nil {
+		t.Errorf("NewGannoyIndexWithOptions should not return error, but %v", err)
+	}
+	if config.Tree != 2 || config.Dim != 3 || config.K != 4 {
+		t.Errorf("StorageFactory should receive database attributes, but %v", config)
+	}
+	if gannoy.numWorker != 1 {
+		t.Errorf("NewGannoyIndexWithOptions should use worker count, but %d", gannoy.numWorker)
+	}
+	if _, ok := gannoy.distance.(Angular); !ok {
+		t.Errorf("NewGannoyIndexWithOptions should use Angular by default.")
+	}
+
+	items := [][]float64{
+		{1.1, 1.2, 1.3},
+		{-1.1, -1.2, -1.3},
+		{1.1, 1.2, 1.3},
+		{-1.1, -1.2, -1.3},
+		{-1.1, -1.2, -1.3},
+	}
+	for i, item := range items {
+		if err := gannoy.AddItem(i*10, item); err != nil {
+			t.Errorf("GannoyIndex AddItem with plugged storage should not return error, but %v", err)
+		}
+	}
+	nns, _ := gannoy.GetNnsByKey(20, 2, -1)
+	if len(nns) != 2 || len(storage.nodes) == 0 {
+		t.Errorf("GannoyIndex should store nodes into plugged storage, but %v", nns)
+	}
+
+	// reload from plugged storage
+	reloaded, _ := NewGannoyIndexWithOptions(name+".meta", Options{
+		Storage: func(c StorageConfig) (Storage, error) { return storage, nil },
+	})
+	if reloaded.nodes.maps.count() != len(items) {
+		t.Errorf("GannoyIndex should load nodes from plugged storage.")
+	}
+}
+
+func TestGannoyIndexWithOptionsReadOnly(t *testing.T) {
+	name := "test_gannoy_index_with_options_read_only"
+	CreateMeta(".", name, 2, 3, 4)
+	defer os.Remove(name + ".meta")
+	defer os.Remove(name + ".tree")
+	defer os.Remove(name + ".leaves")
+	defer os.Remove(name + ".changes")
+	defer os.Remove(name + ".wal")
+
+	// Files must exist because they are never created in read-only mode.
+	if _, err := NewGannoyIndexWithOptions(name+".meta", Options{ReadOnly: true}); err == nil {
+		t.Errorf("Read-only GannoyIndex without tree file should return error.")
+	}
+	if _, err := os.Stat(name + ".tree"); err == nil {
+		t.Errorf("Read-only GannoyIndex should not create tree file.")
+	}
+
+	writer, _ := NewGannoyIndexWithOptions(name+".meta", Options{})
+	writer.AddItem(0, []float64{1.1, 1.2, 1.3})
+	writer.AddItem(1, []float64{-1.1, -1.2, -1.3})
+	tree, _ := os.Stat(name + ".tree")
+
+	gannoy, err := NewGannoyIndexWithOptions(name+".meta", Options{ReadOnly: true, ChangeLog: true, WAL: true})
+	if err != nil {
+		t.Fatalf("Read-only GannoyIndex should open existing files, but %v", err)
+	}
+	if r, err := gannoy.GetNnsByKey(0, 1, -1); err != nil || len(r) != 1 || r[0] != 0 {
+		t.Errorf("Read-only GannoyIndex should search items, but %v, %v", r, err)
+	}
+	if err := gannoy.AddItem(10, []float64{1.1, 1.2, 1.3}); err != ErrReadOnly {
+		t.Errorf("Read-only GannoyIndex AddItem should return ErrReadOnly, but %v", err)
+	}
+	if err := gannoy.RemoveItem(10); err != ErrReadOnly {
+		t.Errorf("Read-only GannoyIndex RemoveItem should return ErrReadOnly, but %v", err)
+	}
+	if err := gannoy.UpdateItem(10, []float64{1.1, 1.2, 1.3}); err != ErrReadOnly {
+		t.Errorf("Read-only GannoyIndex UpdateItem should return ErrReadOnly, but %v", err)
+	}
+	if _, err := gannoy.AddItemAsync(10, []float64{1.1, 1.2, 1.3}); err != ErrReadOnly {
+		t.Errorf("Read-only GannoyIndex AddItemAsync should return ErrReadOnly, but %v", err)
+	}
+	if _, err := gannoy.nodes.Storage.Create(Node{}); err != ErrReadOnly {
+		t.Errorf("Read-only storage Create should return ErrReadOnly, but %v", err)
+	}
+	if err := gannoy.meta.updateRoot(0, 1); err != ErrReadOnly {
+		t.Errorf("Read-only meta updateRoot should return ErrReadOnly, but %v", err)
+	}
+	if info, _ := os.Stat(name + ".tree"); info.Size() != tree.Size() {
+		t.Errorf("Read-only GannoyIndex should not write tree file.")
+	}
+	for _, ext := range []string{".changes", ".wal"} {
+		if _, err := os.Stat(name + ext); err == nil {
+			t.Errorf("Read-only GannoyIndex should not create %s file.", ext)
+		}
+	}
+}
+
+func TestGannoyIndexWithOptionsCloseOnError(t *testing.T) {
+	name := "test_gannoy_index_with_options_close_on_error"
+	CreateMeta(".", name, 2, 3, 4)
+	defer os.Remove(name + ".meta")
+	defer os.Remove(name + ".tree")
+	defer os.Remove(name + ".leaves")
+	defer os.Remove(name + ".changes")
+	defer os.Remove(name + ".wal")
+	ioutil.WriteFile(name+".wal", make([]byte, headerSize), 0666)
+
+	fds, _ := ioutil.ReadDir("/proc/self/fd")
+	for i := 0; i < 10; i++ {
+		if _, err := NewGannoyIndexWithOptions(name+".meta", Options{ChangeLog: true, WAL: true}); err == nil {
+			t.Errorf("NewGannoyIndexWithOptions with broken write-ahead log should return error.")
+		}
+	}
+	after, _ := ioutil.ReadDir("/proc/self/fd")
+	if len(after) > len(fds)+5 {
+		t.Errorf("NewGannoyIndexWithOptions should close files on error, but %d descriptors are left open", len(after)-len(fds))
+	}
+}
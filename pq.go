@@ -0,0 +1,275 @@
+package gannoy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+)
+
+// Number of centroids of each subspace. A code of subspace is 1 byte.
+const pqCentroids = 256
+
+// Codebook is a product quantization codebook.
+// A vector is split into M subvectors and each subvector is encoded
+// to index of the nearest centroid of the subspace.
+type Codebook struct {
+	M         int
+	dim       int
+	centroids [][][]float64 // [M][pqCentroids][dim/M]
+}
+
+type PQOptions struct {
+	M         int // number of subspaces
+	Samples   int // number of leaves used for training
+	Iteration int // number of k-means iteration
+}
+
+// TrainCodebook trains codebook by k-means of each subspace.
+func TrainCodebook(vecs [][]float64, M, iteration int, random Random) (Codebook, error) {
+	if len(vecs) == 0 {
+		return Codebook{}, fmt.Errorf("No vectors to train.")
+	}
+	dim := len(vecs[0])
+	if M <= 0 || dim%M != 0 {
+		return Codebook{}, fmt.Errorf("dim must be a multiple of M, but dim %d and M %d.", dim, M)
+	}
+	sub := dim / M
+	codebook := Codebook{M: M, dim: dim, centroids: make([][][]float64, M)}
+	for m := 0; m < M; m++ {
+		subvecs := make([][]float64, len(vecs))
+		for i, vec := range vecs {
+			subvecs[i] = vec[m*sub : (m+1)*sub]
+		}
+		codebook.centroids[m] = kmeans(subvecs, pqCentroids, iteration, random)
+	}
+	return codebook, nil
+}
+
+func kmeans(vecs [][]float64, k, iteration int, random Random) [][]float64 {
+	sub := len(vecs[0])
+	centroids := make([][]float64, k)
+	for c := 0; c < k; c++ {
+		centroids[c] = make([]float64, sub)
+		copy(centroids[c], vecs[random.index(len(vecs))])
+	}
+
+	assign := make([]int, len(vecs))
+	for l := 0; l < iteration; l++ {
+		for i, vec := range vecs {
+			assign[i] = nearestCentroid(centroids, vec)
+		}
+		sums := make([][]float64, k)
+		counts := make([]int, k)
+		for c := 0; c < k; c++ {
+			sums[c] = make([]float64, sub)
+		}
+		for i, vec := range vecs {
+			for z, x := range vec {
+				sums[assign[i]][z] += x
+			}
+			counts[assign[i]]++
+		}
+		for c := 0; c < k; c++ {
+			if counts[c] == 0 {
+				// Restart empty cluster from random vector.
+				copy(centroids[c], vecs[random.index(len(vecs))])
+				continue
+			}
+			for z := 0; z < sub; z++ {
+				centroids[c][z] = sums[c][z] / float64(counts[c])
+			}
+		}
+	}
+	return centroids
+}
+
+func nearestCentroid(centroids [][]float64, v []float64) int {
+	best := 0
+	bestDist := math.Inf(1)
+	for c, centroid := range centroids {
+		var d float64
+		for z, x := range v {
+			d += (x - centroid[z]) * (x - centroid[z])
+		}
+		if d < bestDist {
+			best = c
+			bestDist = d
+		}
+	}
+	return best
+}
+
+func (c Codebook) sub() int {
+	return c.dim / c.M
+}
+
+func (c Codebook) encode(v []float64) []byte {
+	sub := c.sub()
+	codes := make([]byte, c.M)
+	for m := 0; m < c.M; m++ {
+		codes[m] = byte(nearestCentroid(c.centroids[m], v[m*sub:(m+1)*sub]))
+	}
+	return codes
+}
+
+func (c Codebook) decode(codes []byte) []float64 {
+	sub := c.sub()
+	v := make([]float64, c.dim)
+	for m := 0; m < c.M; m++ {
+		copy(v[m*sub:(m+1)*sub], c.centroids[m][codes[m]])
+	}
+	return v
+}
+
+// adcTable is an asymmetric distance table of a query.
+type adcTable struct {
+	dot   [][]float64 // dot product of query and each centroid
+	norm  [][]float64 // squared norm of each centroid
+	qnorm float64     // squared norm of query
+}
+
+func (c Codebook) table(v []float64) adcTable {
+	sub := c.sub()
+	t := adcTable{
+		dot:  make([][]float64, c.M),
+		norm: make([][]float64, c.M),
+	}
+	for _, x := range v {
+		t.qnorm += x * x
+	}
+	for m := 0; m < c.M; m++ {
+		t.dot[m] = make([]float64, len(c.centroids[m]))
+		t.norm[m] = make([]float64, len(c.centroids[m]))
+		q := v[m*sub : (m+1)*sub]
+		for i, centroid := range c.centroids[m] {
+			for z, x := range centroid {
+				t.dot[m][i] += q[z] * x
+				t.norm[m][i] += x * x
+			}
+		}
+	}
+	return t
+}
+
+// angular returns angular distance between query and the encoded vector.
+func (t adcTable) angular(codes []byte) float64 {
+	var pq, qq float64
+	for m, code := range codes {
+		pq += t.dot[m][code]
+		qq += t.norm[m][code]
+	}
+	ppqq := t.qnorm * qq
+	if ppqq > 0 {
+		return 2.0 - 2.0*pq/math.Sqrt(ppqq)
+	}
+	return 2.0
+}
+
+func (c Codebook) save(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	binary.Write(f, binary.BigEndian, int32(c.M))
+	binary.Write(f, binary.BigEndian, int32(c.dim))
+	binary.Write(f, binary.BigEndian, int32(pqCentroids))
+	for m := 0; m < c.M; m++ {
+		for _, centroid := range c.centroids[m] {
+			if err := binary.Write(f, binary.BigEndian, centroid); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func loadCodebook(filename string) (Codebook, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return Codebook{}, err
+	}
+	defer f.Close()
+
+	var M, dim, k int32
+	binary.Read(f, binary.BigEndian, &M)
+	binary.Read(f, binary.BigEndian, &dim)
+	if err := binary.Read(f, binary.BigEndian, &k); err != nil {
+		return Codebook{}, err
+	}
+	if M <= 0 || dim%M != 0 || k != pqCentroids {
+		return Codebook{}, fmt.Errorf("Invalid codebook: %s.", filename)
+	}
+	c := Codebook{M: int(M), dim: int(dim), centroids: make([][][]float64, M)}
+	sub := c.sub()
+	for m := 0; m < c.M; m++ {
+		c.centroids[m] = make([][]float64, k)
+		for i := 0; i < int(k); i++ {
+			c.centroids[m][i] = make([]float64, sub)
+			if err := binary.Read(f, binary.BigEndian, c.centroids[m][i]); err != nil {
+				return Codebook{}, err
+			}
+		}
+	}
+	return c, nil
+}
+
+type pqCodec struct {
+	codebook Codebook
+}
+
+func (c pqCodec) size() int {
+	return c.codebook.M
+}
+
+func (c pqCodec) encode(b []byte, v []float64) {
+	copy(b, c.codebook.encode(v))
+}
+
+func (c pqCodec) decode(b []byte) []float64 {
+	return c.codebook.decode(b[:c.size()])
+}
+
+// codeFinder is implemented by storages which can read PQ codes of leaves
+// without decoding them.
+type codeFinder interface {
+	findCode(int) (int, []byte, error)
+}
+
+// TrainPQ trains codebook from sampled leaves of the database.
+func (g *GannoyIndex) TrainPQ(opts PQOptions) (Codebook, error) {
+	keys := g.nodes.maps.keys()
+	samples := opts.Samples
+	if samples <= 0 || samples > len(keys) {
+		samples = len(keys)
+	}
+	vecs := make([][]float64, samples)
+	for i := 0; i < samples; i++ {
+		j := i + g.random.index(len(keys)-i)
+		keys[i], keys[j] = keys[j], keys[i]
+		node, err := g.nodes.getNodeByKey(keys[i])
+		if err != nil {
+			return Codebook{}, err
+		}
+		if vecs[i], err = g.vector(node); err != nil {
+			return Codebook{}, err
+		}
+	}
+	return TrainCodebook(vecs, opts.M, opts.Iteration, g.random)
+}
+
+// pqDistances scores candidates by asymmetric distance table.
+func (g *GannoyIndex) pqDistances(finder codeFinder, v []float64, ids []int) ([]sorter, error) {
+	table := g.meta.codebook.table(v)
+	nnsDist := make([]sorter, len(ids))
+	for i, id := range ids {
+		key, codes, err := finder.findCode(id)
+		if err != nil {
+			return nil, err
+		}
+		nnsDist[i] = sorter{value: table.angular(codes), id: key}
+	}
+	return nnsDist, nil
+}
@@ -0,0 +1,130 @@
+package gannoy
+
+import (
+	"math"
+	"os"
+	"testing"
+)
+
+func testPQVectors() [][]float64 {
+	vecs := [][]float64{}
+	for i := 0; i < 50; i++ {
+		vecs = append(vecs, []float64{
+			float64(i%5) + 0.1, float64(i%3) + 0.1,
+			float64(i%7) + 0.1, float64(i%2) + 0.1,
+		})
+	}
+	return vecs
+}
+
+func TestTrainCodebook(t *testing.T) {
+	_, err := TrainCodebook(testPQVectors(), 3, 5, RandRandom{})
+	if err == nil {
+		t.Errorf("TrainCodebook with dim not multiple of M should return error.")
+	}
+
+	vecs := testPQVectors()
+	codebook, err := TrainCodebook(vecs, 2, 5, RandRandom{})
+	if err != nil {
+		t.Errorf("TrainCodebook should not return error.")
+	}
+	if len(codebook.centroids) != 2 || len(codebook.centroids[0]) != pqCentroids {
+		t.Errorf("Codebook should contain %d centroids for each subspace.", pqCentroids)
+	}
+
+	// Few distinct subvectors are encoded without loss.
+	for _, v := range vecs {
+		codes := codebook.encode(v)
+		if len(codes) != 2 {
+			t.Errorf("Codes should contain M bytes, but %d", len(codes))
+		}
+		for z, x := range codebook.decode(codes) {
+			if math.Abs(x-v[z]) > 1e-9 {
+				t.Errorf("Codebook should decode %v, but %v", v, codebook.decode(codes))
+				break
+			}
+		}
+	}
+}
+
+func TestADCTable(t *testing.T) {
+	codebook, _ := TrainCodebook(testPQVectors(), 2, 5, RandRandom{})
+	q := []float64{1.0, -0.5, 0.3, 2.0}
+	table := codebook.table(q)
+	for _, v := range testPQVectors() {
+		codes := codebook.encode(v)
+		expect := Angular{}.distance(q, codebook.decode(codes))
+		if d := table.angular(codes); math.Abs(d-expect) > 1e-9 {
+			t.Errorf("ADC distance should be %f, but %f", expect, d)
+		}
+	}
+}
+
+func TestCodebookSaveAndLoad(t *testing.T) {
+	name := "test_codebook_save_and_load.pq"
+	defer os.Remove(name)
+
+	codebook, _ := TrainCodebook(testPQVectors(), 2, 5, RandRandom{})
+	if err := codebook.save(name); err != nil {
+		t.Errorf("Codebook save should not return error.")
+	}
+	loaded, err := loadCodebook(name)
+	if err != nil {
+		t.Errorf("loadCodebook should not return error.")
+	}
+	if loaded.M != codebook.M || loaded.dim != codebook.dim {
+		t.Errorf("Loaded codebook should have M %d and dim %d, but %d and %d", codebook.M, codebook.dim, loaded.M, loaded.dim)
+	}
+	for i, centroid := range codebook.centroids[1] {
+		for z, x := range centroid {
+			if loaded.centroids[1][i][z] != x {
+				t.Errorf("Loaded codebook should have same centroids.")
+				return
+			}
+		}
+	}
+}
+
+func TestGannoyIndexPQEncoding(t *testing.T) {
+	src := "test_gannoy_index_pq_encoding_src"
+	dest := "test_gannoy_index_pq_encoding"
+	CreateMeta(".", src, 2, 4, 3)
+	defer os.Remove(src + ".meta")
+	defer os.Remove(src + ".tree")
+	defer os.Remove(src + ".leaves")
+	for _, ext := range []string{".meta", ".tree", ".leaves", ".vec", ".pq"} {
+		defer os.Remove(dest + ext)
+	}
+
+	gannoy, _ := NewGannoyIndex(src+".meta", Angular{}, RandRandom{})
+	for i, v := range testPQVectors() {
+		gannoy.AddItem(i, v)
+	}
+
+	codebook, err := gannoy.TrainPQ(PQOptions{M: 2, Samples: 30, Iteration: 5})
+	if err != nil {
+		t.Errorf("GannoyIndex TrainPQ should not return error, but %v", err)
+	}
+	err = gannoy.Rebuild(".", dest, MetaOptions{Encoding: PQ, Codebook: &codebook})
+	if err != nil {
+		t.Errorf("GannoyIndex Rebuild should not return error, but %v", err)
+	}
+
+	pq, err := NewGannoyIndex(dest+".meta", Angular{}, RandRandom{})
+	if err != nil {
+		t.Errorf("NewGannoyIndex with pq encoding should not return error, but %v", err)
+	}
+	if pq.meta.encoding != PQ || pq.nodes.maps.count() != len(testPQVectors()) {
+		t.Errorf("Rebuilt database should be encoded by pq and contain all items.")
+	}
+
+	pq.Rerank = 10
+	nns, err := pq.GetNnsByKey(0, 3, 100)
+	if err != nil || len(nns) != 3 {
+		t.Errorf("GannoyIndex GetNnsByKey with pq encoding should return specified size list, but %v", nns)
+	}
+	// (0.1, 0.1, 0.1, 0.1) and (1.1, 1.1, 1.1, 1.1) are the same direction.
+	if nns[0] != 0 && nns[0] != 1 {
+		t.Errorf("GannoyIndex GetNnsByKey with pq encoding should re-rank by full-precision vectors, but %v", nns)
+	}
+}
@@ -0,0 +1,158 @@
+package gannoy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Maximum number of changes pulled by a request of follower.
+const replicationBatch = 1000
+
+var errStopChanges = errors.New("stop reading changes")
+
+// ChangeSet is a part of change log sent from leader to followers.
+type ChangeSet struct {
+	LastSeq uint64   `json:"last_seq"` // last sequence number of leader
+	Changes []Change `json:"changes"`
+}
+
+// Changes returns at most limit changes whose sequence number is greater than since.
+func (g GannoyIndex) Changes(since uint64, limit int) (ChangeSet, error) {
+	if g.changes == nil {
+		return ChangeSet{}, fmt.Errorf("Change log is disabled.")
+	}
+	set := ChangeSet{LastSeq: g.changes.lastSeq(), Changes: []Change{}}
+	err := g.changes.read(since, func(c Change) error {
+		if limit > 0 && len(set.Changes) >= limit {
+			return errStopChanges
+		}
+		set.Changes = append(set.Changes, c)
+		return nil
+	})
+	if err != nil && err != errStopChanges {
+		return ChangeSet{}, err
+	}
+	return set, nil
+}
+
+// ReplicationStatus reports progress of a follower.
+type ReplicationStatus struct {
+	Leader       string    `json:"leader"`
+	AppliedSeq   uint64    `json:"applied_seq"`
+	LeaderSeq    uint64    `json:"leader_seq"`
+	Lag          uint64    `json:"lag"` // number of changes not applied yet
+	LastSyncedAt time.Time `json:"last_synced_at"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// Follower pulls changes of a database from leader over HTTP
+// and applies them through the builder.
+type Follower struct {
+	index  *GannoyIndex
+	url    string
+	client *http.Client
+
+	mu     sync.RWMutex
+	status ReplicationStatus
+}
+
+// NewFollower returns a follower of the database on leader such as http://leader:1323.
+// The index must be opened with change log to keep applied sequence number.
+func NewFollower(index *GannoyIndex, leader, database string) (*Follower, error) {
+	if index.changes == nil {
+		return nil, fmt.Errorf("Follower requires change log.")
+	}
+	return &Follower{
+		index:  index,
+		url:    fmt.Sprintf("%s/databases/%s/changes", leader, url.PathEscape(database)),
+		client: &http.Client{Timeout: 30 * time.Second},
+		status: ReplicationStatus{
+			Leader:     leader,
+			AppliedSeq: index.changes.lastSeq(),
+		},
+	}, nil
+}
+
+// Sync pulls and applies changes until follower catches up with leader.
+func (f *Follower) Sync() error {
+	for {
+		set, err := f.pull(f.index.changes.lastSeq())
+		if err == nil {
+			err = f.apply(set)
+		}
+		f.update(set, err)
+		if err != nil || len(set.Changes) == 0 {
+			return err
+		}
+	}
+}
+
+// Run syncs every interval until stop is closed.
+func (f *Follower) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		f.Sync()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (f *Follower) Status() ReplicationStatus {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.status
+}
+
+func (f *Follower) pull(since uint64) (ChangeSet, error) {
+	res, err := f.client.Get(fmt.Sprintf("%s?since=%d&limit=%d", f.url, since, replicationBatch))
+	if err != nil {
+		return ChangeSet{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return ChangeSet{}, fmt.Errorf("Leader returned %s.", res.Status)
+	}
+	var set ChangeSet
+	if err := json.NewDecoder(res.Body).Decode(&set); err != nil {
+		return ChangeSet{}, err
+	}
+	return set, nil
+}
+
+func (f *Follower) apply(set ChangeSet) error {
+	for _, c := range set.Changes {
+		if err := f.index.Replay(c); err != nil {
+			return fmt.Errorf("Replay of change %d failed: %v", c.Seq, err)
+		}
+	}
+	return nil
+}
+
+func (f *Follower) update(set ChangeSet, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.status.AppliedSeq = f.index.changes.lastSeq()
+	if err != nil {
+		f.status.Error = err.Error()
+	} else {
+		f.status.Error = ""
+		f.status.LeaderSeq = set.LastSeq
+		f.status.LastSyncedAt = time.Now()
+	}
+	if f.status.LeaderSeq > f.status.AppliedSeq {
+		f.status.Lag = f.status.LeaderSeq - f.status.AppliedSeq
+	} else {
+		f.status.Lag = 0
+	}
+}
@@ -0,0 +1,118 @@
+package gannoy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestFollowerSync(t *testing.T) {
+	leaderName := "test_follower_sync_leader"
+	followerName := "test_follower_sync_follower"
+	for _, name := range []string{leaderName, followerName} {
+		CreateMeta(".", name, 2, 3, 4)
+		defer os.Remove(name + ".meta")
+		defer os.Remove(name + ".tree")
+		defer os.Remove(name + ".leaves")
+		defer os.Remove(name + ".changes")
+	}
+
+	leader, _ := NewGannoyIndexWithOptions(leaderName+".meta", Options{ChangeLog: true})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/databases/"+leaderName+"/changes" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		since, _ := strconv.ParseUint(r.URL.Query().Get("since"), 10, 64)
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		set, _ := leader.Changes(since, limit)
+		json.NewEncoder(w).Encode(set)
+	}))
+	defer server.Close()
+
+	index, _ := NewGannoyIndexWithOptions(followerName+".meta", Options{})
+	if _, err := NewFollower(&index, server.URL, leaderName); err == nil {
+		t.Errorf("NewFollower without change log should return error.")
+	}
+	index, _ = NewGannoyIndexWithOptions(followerName+".meta", Options{ChangeLog: true})
+	follower, _ := NewFollower(&index, server.URL, leaderName)
+
+	leader.AddItem(0, []float64{1.1, 1.2, 1.3})
+	leader.AddItem(10, []float64{-1.1, -1.2, -1.3})
+	leader.AddItem(20, []float64{1.1, 1.2, 1.3})
+	leader.RemoveItem(10)
+
+	if err := follower.Sync(); err != nil {
+		t.Errorf("Follower Sync should not return error, but %v", err)
+	}
+	status := follower.Status()
+	if status.AppliedSeq != 4 || status.LeaderSeq != 4 || status.Lag != 0 || status.Error != "" {
+		t.Errorf("Follower should catch up with leader, but %v", status)
+	}
+	if !index.nodes.maps.isExist(20) || index.nodes.maps.isExist(10) {
+		t.Errorf("Follower should apply changes of leader, but %v", index.nodes.maps.keys())
+	}
+
+	// Changes of leader is limited.
+	set, _ := leader.Changes(1, 2)
+	if set.LastSeq != 4 || len(set.Changes) != 2 || set.Changes[0].Seq != 2 {
+		t.Errorf("Leader Changes should return limited changes, but %v", set)
+	}
+
+	// Failure is reported in status.
+	follower, _ = NewFollower(&index, server.URL, "unknown")
+	if err := follower.Sync(); err == nil || follower.Status().Error == "" {
+		t.Errorf("Follower Sync of unknown database should report error, but %v", follower.Status())
+	}
+}
+
+func TestFollowerSyncAfterRestart(t *testing.T) {
+	leaderName := "test_follower_sync_after_restart_leader"
+	followerName := "test_follower_sync_after_restart_follower"
+	for _, name := range []string{leaderName, followerName} {
+		CreateMeta(".", name, 2, 3, 4)
+		defer os.Remove(name + ".meta")
+		defer os.Remove(name + ".tree")
+		defer os.Remove(name + ".leaves")
+		defer os.Remove(name + ".changes")
+	}
+
+	leader, _ := NewGannoyIndexWithOptions(leaderName+".meta", Options{ChangeLog: true})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		since, _ := strconv.ParseUint(r.URL.Query().Get("since"), 10, 64)
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		set, _ := leader.Changes(since, limit)
+		json.NewEncoder(w).Encode(set)
+	}))
+	defer server.Close()
+
+	leader.AddItem(0, []float64{1.1, 1.2, 1.3})
+	leader.AddItem(10, []float64{-1.1, -1.2, -1.3})
+	leader.RemoveItem(0)
+
+	// Changes are applied, but not recorded in change log of follower before crash.
+	index, _ := NewGannoyIndexWithOptions(followerName+".meta", Options{})
+	index.AddItem(0, []float64{1.1, 1.2, 1.3})
+	index.AddItem(10, []float64{-1.1, -1.2, -1.3})
+	index.RemoveItem(0)
+
+	index, _ = NewGannoyIndexWithOptions(followerName+".meta", Options{ChangeLog: true})
+	follower, _ := NewFollower(&index, server.URL, leaderName)
+	if err := follower.Sync(); err != nil {
+		t.Errorf("Follower Sync of applied changes should not return error, but %v", err)
+	}
+	if status := follower.Status(); status.AppliedSeq != 3 || status.Error != "" {
+		t.Errorf("Follower should catch up with leader, but %v", status)
+	}
+	if !index.nodes.maps.isExist(10) || index.nodes.maps.isExist(0) || len(index.nodes.maps.keys()) != 1 {
+		t.Errorf("Follower should apply changes of leader, but %v", index.nodes.maps.keys())
+	}
+
+	// Removal of a missing key is regarded as applied.
+	if err := index.Replay(Change{Seq: 4, Action: "delete", Key: 20}); err != nil || index.changes.lastSeq() != 4 {
+		t.Errorf("Replay of removal of missing key should be applied, but %v", err)
+	}
+}
@@ -20,6 +20,7 @@ Source2:   %{name}-db-%{version}
 Source3:   %{name}-db.toml
 Source4:   %{name}-db.service
 Source5:   %{name}-db.logrotate
+Source6:   %{name}-router-%{version}
 BuildRoot: %{_tmppath}/%{name}-%{version}-%{release}-root
 
 %{?systemd_requires}
@@ -40,6 +41,8 @@ BuildRequires: systemd
 %{__install} -Dp -m0755 %{SOURCE0} %{buildroot}/usr/bin/%{name}
 %{__install} -Dp -m0755 %{SOURCE1} %{buildroot}/usr/bin/%{name}-converter
 %{__install} -Dp -m0755 %{SOURCE2} %{buildroot}/usr/bin/%{name}-db
+/usr/bin/%{name}-router
+%{__install} -Dp -m0755 %{SOURCE6} %{buildroot}/usr/bin/%{name}-router
 %{__install} -Dp -m0644 %{SOURCE3} %{buildroot}%{gannoy_confdir}/%{name}-db.toml
 %{__install} -Dp -m0644 %{SOURCE4} %{buildroot}/usr/lib/systemd/system/%{name}-db.service
 %{__install} -Dp -m0644 %{SOURCE5} %{buildroot}/etc/logrotate.d/%{name}-db
@@ -0,0 +1,319 @@
+package gannoy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// Index is implemented by GannoyIndex and ShardedIndex.
+type Index interface {
+	AddItem(key int, w []float64) error
+	RemoveItem(key int) error
+	UpdateItem(key int, w []float64) error
+	AddItemAsync(key int, w []float64) (uint64, error)
+	UpdateItemAsync(key int, w []float64) (uint64, error)
+	RemoveItemAsync(key int) (uint64, error)
+	GetWriteStatus(id uint64) (WriteStatus, error)
+	WaitWrite(id uint64) (WriteStatus, error)
+	GetItem(key int) ([]float64, error)
+	GetNnsByKey(key, n, searchK int) ([]int, error)
+	GetNnsByKeyExact(key, n int) ([]int, error)
+	GetAllNns(v []float64, n, searchK int) ([]int, error)
+	GetAllNnsExact(v []float64, n int) ([]int, error)
+	Neighbors(v []float64, keys []int) ([]Neighbor, error)
+	Dim() int
+	Stats() (Stats, error)
+	Backup(dir string) error
+	WithRerank(n int) Index
+}
+
+// WithRerank returns a copy of the index which re-ranks n candidates.
+func (g GannoyIndex) WithRerank(n int) Index {
+	g.Rerank = n
+	return &g
+}
+
+// ShardOf returns the shard of the key among shards by FNV-1a hash.
+func ShardOf(key, shards int) int {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(key))
+	h := fnv.New32a()
+	h.Write(b)
+	return int(h.Sum32() % uint32(shards))
+}
+
+var shardNamePattern = regexp.MustCompile(`^(.+)\.shard(\d+)$`)
+
+// ShardName returns database name of the shard.
+func ShardName(name string, shard int) string {
+	return fmt.Sprintf("%s.shard%d", name, shard)
+}
+
+// ParseShardName returns logical database name and shard of the database name.
+func ParseShardName(name string) (string, int, bool) {
+	m := shardNamePattern.FindStringSubmatch(name)
+	if m == nil {
+		return name, 0, false
+	}
+	shard, err := strconv.Atoi(m[2])
+	if err != nil {
+		return name, 0, false
+	}
+	return m[1], shard, true
+}
+
+// CreateShardedMeta creates meta files of shards of the database.
+func CreateShardedMeta(path, name string, shards, tree, dim, K int, opts MetaOptions) error {
+	if shards < 1 {
+		return fmt.Errorf("Invalid number of shards: %d.", shards)
+	}
+	for i := 0; i < shards; i++ {
+		if err := CreateMetaWithOptions(path, ShardName(name, i), tree, dim, K, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ShardedIndex splits a database into independent shards by hash of keys.
+// Writes to different shards are built in parallel, and searches are merged by distance.
+type ShardedIndex struct {
+	shards []*GannoyIndex
+	rerank int
+}
+
+// NewShardedIndexWithOptions opens all shards of the database name in path.
+func NewShardedIndexWithOptions(path, name string, opts Options) (*ShardedIndex, error) {
+	files, err := filepath.Glob(filepath.Join(path, name+".shard*.meta"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("Not found shards of database: %s.", name)
+	}
+	shards := make([]*GannoyIndex, len(files))
+	for _, file := range files {
+		_, shard, ok := ParseShardName(databaseNameOf(file))
+		if !ok || shard >= len(shards) || shards[shard] != nil {
+			return nil, fmt.Errorf("Invalid shard: %s.", file)
+		}
+		index, err := NewGannoyIndexWithOptions(file, opts)
+		if err != nil {
+			return nil, err
+		}
+		shards[shard] = &index
+	}
+	return NewShardedIndex(shards)
+}
+
+// NewShardedIndex returns a database of opened shards in order.
+func NewShardedIndex(shards []*GannoyIndex) (*ShardedIndex, error) {
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("Not found shards.")
+	}
+	for i, shard := range shards {
+		if shard == nil {
+			return nil, fmt.Errorf("Shard %d is not found.", i)
+		}
+		if shard.Dim() != shards[0].Dim() {
+			return nil, fmt.Errorf("Dimension mismatch. expect %d, but %d.", shards[0].Dim(), shard.Dim())
+		}
+	}
+	return &ShardedIndex{shards: shards}, nil
+}
+
+// Shards returns indexes of shards in order.
+func (s *ShardedIndex) Shards() []*GannoyIndex {
+	return s.shards
+}
+
+func (s *ShardedIndex) shard(key int) *GannoyIndex {
+	return s.shards[ShardOf(key, len(s.shards))]
+}
+
+func (s *ShardedIndex) AddItem(key int, w []float64) error {
+	return s.shard(key).AddItem(key, w)
+}
+
+func (s *ShardedIndex) RemoveItem(key int) error {
+	return s.shard(key).RemoveItem(key)
+}
+
+func (s *ShardedIndex) UpdateItem(key int, w []float64) error {
+	return s.shard(key).UpdateItem(key, w)
+}
+
+// Ids of asynchronous writes are numbered in each shard, and interleaved by shard.
+
+func (s *ShardedIndex) AddItemAsync(key int, w []float64) (uint64, error) {
+	return s.writeId(key, func(g *GannoyIndex) (uint64, error) { return g.AddItemAsync(key, w) })
+}
+
+func (s *ShardedIndex) UpdateItemAsync(key int, w []float64) (uint64, error) {
+	return s.writeId(key, func(g *GannoyIndex) (uint64, error) { return g.UpdateItemAsync(key, w) })
+}
+
+func (s *ShardedIndex) RemoveItemAsync(key int) (uint64, error) {
+	return s.writeId(key, func(g *GannoyIndex) (uint64, error) { return g.RemoveItemAsync(key) })
+}
+
+func (s *ShardedIndex) GetWriteStatus(id uint64) (WriteStatus, error) {
+	return s.writeStatus(id, (*GannoyIndex).GetWriteStatus)
+}
+
+func (s *ShardedIndex) WaitWrite(id uint64) (WriteStatus, error) {
+	return s.writeStatus(id, (*GannoyIndex).WaitWrite)
+}
+
+func (s *ShardedIndex) writeId(key int, f func(*GannoyIndex) (uint64, error)) (uint64, error) {
+	shard := ShardOf(key, len(s.shards))
+	id, err := f(s.shards[shard])
+	if err != nil {
+		return 0, err
+	}
+	return id*uint64(len(s.shards)) + uint64(shard), nil
+}
+
+func (s *ShardedIndex) writeStatus(id uint64, f func(*GannoyIndex, uint64) (WriteStatus, error)) (WriteStatus, error) {
+	n := uint64(len(s.shards))
+	status, err := f(s.shards[id%n], id/n)
+	if err != nil {
+		return WriteStatus{}, err
+	}
+	status.ID = id
+	return status, nil
+}
+
+func (s *ShardedIndex) GetItem(key int) ([]float64, error) {
+	return s.shard(key).GetItem(key)
+}
+
+func (s *ShardedIndex) GetNnsByKey(key, n, searchK int) ([]int, error) {
+	v, err := s.GetItem(key)
+	if err != nil {
+		return []int{}, err
+	}
+	return s.GetAllNns(v, n, searchK)
+}
+
+func (s *ShardedIndex) GetNnsByKeyExact(key, n int) ([]int, error) {
+	v, err := s.GetItem(key)
+	if err != nil {
+		return []int{}, err
+	}
+	return s.GetAllNnsExact(v, n)
+}
+
+func (s *ShardedIndex) GetAllNns(v []float64, n, searchK int) ([]int, error) {
+	return s.search(v, n, func(g Index) ([]int, error) {
+		return g.GetAllNns(v, n, searchK)
+	})
+}
+
+func (s *ShardedIndex) GetAllNnsExact(v []float64, n int) ([]int, error) {
+	return s.search(v, n, func(g Index) ([]int, error) {
+		return g.GetAllNnsExact(v, n)
+	})
+}
+
+// search runs f on all shards in parallel, and merges their results by distance.
+func (s *ShardedIndex) search(v []float64, n int, f func(Index) ([]int, error)) ([]int, error) {
+	results := make([][]Neighbor, len(s.shards))
+	errs := make([]error, len(s.shards))
+	var wg sync.WaitGroup
+	for i, shard := range s.shards {
+		wg.Add(1)
+		go func(i int, shard *GannoyIndex) {
+			defer wg.Done()
+			var g Index = shard
+			if s.rerank > 0 {
+				g = shard.WithRerank(s.rerank)
+			}
+			keys, err := f(g)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			results[i], errs[i] = g.Neighbors(v, keys)
+		}(i, shard)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return []int{}, err
+		}
+	}
+	return MergeNeighbors(results, n), nil
+}
+
+func (s *ShardedIndex) Neighbors(v []float64, keys []int) ([]Neighbor, error) {
+	neighbors := []Neighbor{}
+	for _, key := range keys {
+		found, err := s.shard(key).Neighbors(v, []int{key})
+		if err != nil {
+			return []Neighbor{}, err
+		}
+		neighbors = append(neighbors, found...)
+	}
+	return neighbors, nil
+}
+
+func (s *ShardedIndex) Dim() int {
+	return s.shards[0].Dim()
+}
+
+// Stats sums up statistics of shards. Trees of all shards are reported in order.
+func (s *ShardedIndex) Stats() (Stats, error) {
+	var stats Stats
+	for i, shard := range s.shards {
+		st, err := shard.Stats()
+		if err != nil {
+			return Stats{}, err
+		}
+		if i == 0 {
+			stats = st
+			stats.Cache = nil
+			stats.ChangeSeq = 0
+			stats.Shards = len(s.shards)
+			continue
+		}
+		stats.Items += st.Items
+		stats.Nodes += st.Nodes
+		stats.FreeNodes += st.FreeNodes
+		stats.FileSize += st.FileSize
+		stats.Trees = append(stats.Trees, st.Trees...)
+	}
+	return stats, nil
+}
+
+// Backup copies files of each shard into dir. Writes of all shards are paused
+// until all of them are copied, so that the backup is a point in time.
+func (s *ShardedIndex) Backup(dir string) error {
+	for _, shard := range s.shards {
+		if shard.meta.path == "" {
+			return fmt.Errorf("Database in memory can not be backed up. Use Snapshot.")
+		}
+	}
+	for _, shard := range s.shards {
+		defer shard.pauseWrites()()
+	}
+	for _, shard := range s.shards {
+		if err := shard.backup(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WithRerank returns a copy of the index which re-ranks n candidates in each shard.
+func (s *ShardedIndex) WithRerank(n int) Index {
+	c := *s
+	c.rerank = n
+	return &c
+}
@@ -0,0 +1,165 @@
+package gannoy
+
+import (
+	"os"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestShardOf(t *testing.T) {
+	shards := 4
+	counts := make([]int, shards)
+	for key := -100; key < 1000; key++ {
+		shard := ShardOf(key, shards)
+		if shard < 0 || shard >= shards {
+			t.Fatalf("ShardOf should return shard less than %d, but %d", shards, shard)
+		}
+		if shard != ShardOf(key, shards) {
+			t.Errorf("ShardOf should return the same shard for the same key.")
+		}
+		counts[shard]++
+	}
+	for shard, count := range counts {
+		if count == 0 {
+			t.Errorf("ShardOf should distribute keys to all shards, but shard %d is empty.", shard)
+		}
+	}
+}
+
+func TestParseShardName(t *testing.T) {
+	name, shard, ok := ParseShardName(ShardName("catalog", 12))
+	if !ok || name != "catalog" || shard != 12 {
+		t.Errorf("ParseShardName should return catalog and 12, but %s and %d", name, shard)
+	}
+	if _, _, ok := ParseShardName("catalog"); ok {
+		t.Errorf("ParseShardName of database without shard should return false.")
+	}
+}
+
+func TestShardedIndex(t *testing.T) {
+	name := "test_sharded_index"
+	shards := 3
+	CreateShardedMeta(".", name, shards, 2, 3, 4, MetaOptions{})
+	for i := 0; i < shards; i++ {
+		shard := ShardName(name, i)
+		defer os.Remove(shard + ".meta")
+		defer os.Remove(shard + ".tree")
+		defer os.Remove(shard + ".leaves")
+	}
+
+	if _, err := NewShardedIndexWithOptions(".", "unknown", Options{}); err == nil {
+		t.Errorf("NewShardedIndexWithOptions of unknown database should return error.")
+	}
+	index, err := NewShardedIndexWithOptions(".", name, Options{})
+	if err != nil {
+		t.Fatalf("NewShardedIndexWithOptions should not return error, but %v", err)
+	}
+
+	count := 30
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(key int) {
+			defer wg.Done()
+			index.AddItem(key, []float64{float64(key%3) + 0.1, float64(key%5) + 0.1, float64(key%7) + 0.1})
+		}(i)
+	}
+	wg.Wait()
+
+	for i, shard := range index.Shards() {
+		for _, key := range shard.nodes.maps.keys() {
+			if ShardOf(key, shards) != i {
+				t.Errorf("Key %d should be added to shard %d, but %d", key, ShardOf(key, shards), i)
+			}
+		}
+	}
+	stats, _ := index.Stats()
+	if stats.Items != count || stats.Shards != shards || len(stats.Trees) != 2*shards {
+		t.Errorf("ShardedIndex Stats should sum up shards, but %v", stats)
+	}
+
+	// Same vectors in other shards are found.
+	nns, err := index.GetNnsByKeyExact(0, 3)
+	if err != nil || len(nns) != 3 {
+		t.Fatalf("ShardedIndex GetNnsByKeyExact should return %d items, but %v (%v)", 3, nns, err)
+	}
+	keys := make([]int, count)
+	for i := range keys {
+		keys[i] = i
+	}
+	v, _ := index.GetItem(0)
+	all, _ := index.Neighbors(v, keys)
+	sort.Slice(all, func(i, j int) bool { return all[i].Distance < all[j].Distance })
+	found, _ := index.Neighbors(v, nns)
+	for _, neighbor := range found {
+		if neighbor.Distance > all[2].Distance {
+			t.Errorf("ShardedIndex GetNnsByKeyExact should return nearest items, but %v", found)
+			break
+		}
+	}
+	if nns, _ := index.WithRerank(10).GetNnsByKey(0, 3, -1); len(nns) == 0 {
+		t.Errorf("ShardedIndex GetNnsByKey should return items.")
+	}
+	if _, err := index.GetNnsByKey(count, 3, -1); err == nil {
+		t.Errorf("ShardedIndex GetNnsByKey should return error if key is not found.")
+	}
+
+	index.RemoveItem(0)
+	if _, err := index.GetItem(0); err == nil {
+		t.Errorf("ShardedIndex RemoveItem should remove item from its shard.")
+	}
+}
+
+func TestShardedIndexBackup(t *testing.T) {
+	name := "test_sharded_index_backup"
+	shards := 3
+	CreateShardedMeta(".", name, shards, 2, 3, 4, MetaOptions{})
+	for i := 0; i < shards; i++ {
+		shard := ShardName(name, i)
+		defer os.Remove(shard + ".meta")
+		defer os.Remove(shard + ".tree")
+		defer os.Remove(shard + ".leaves")
+	}
+	dir := "test_sharded_index_backup_dir"
+	defer os.RemoveAll(dir)
+
+	index, _ := NewShardedIndexWithOptions(".", name, Options{})
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for key := 0; ; key++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			index.AddItem(key, []float64{float64(key%3) + 0.1, float64(key%5) + 0.1, float64(key%7) + 0.1})
+		}
+	}()
+
+	for i := 0; i < 5; i++ {
+		if err := index.Backup(dir); err != nil {
+			t.Errorf("ShardedIndex Backup should not return error, but %v", err)
+		}
+		backup, err := NewShardedIndexWithOptions(dir, name, Options{ReadOnly: true})
+		if err != nil {
+			t.Fatalf("Backup of ShardedIndex should be opened, but %v", err)
+		}
+		// Keys are added in order, so that a point in time has keys up to the last one.
+		keys := []int{}
+		for _, shard := range backup.Shards() {
+			keys = append(keys, shard.nodes.maps.keys()...)
+		}
+		sort.Ints(keys)
+		for j, key := range keys {
+			if key != j {
+				t.Errorf("Backup of ShardedIndex should be a point in time, but has %d without %d", key, j)
+				break
+			}
+		}
+	}
+	close(stop)
+	<-done
+}
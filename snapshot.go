@@ -0,0 +1,114 @@
+package gannoy
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Snapshot writes nodes and roots into new meta and tree files named name in path.
+// Free nodes are dropped, and ids of nodes are given by the new files.
+func (g GannoyIndex) Snapshot(path, name string) error {
+	return g.snapshot(path, name, g.meta.options())
+}
+
+func (g GannoyIndex) snapshot(path, name string, opts MetaOptions) error {
+	// Writes between passes over nodes would leave links to nodes not copied.
+	defer g.pauseWrites()()
+
+	err := CreateMetaWithOptions(path, name, g.tree, g.dim, g.K, opts)
+	if err != nil {
+		return err
+	}
+	m, err := loadMeta(filepath.Join(path, name+".meta"))
+	if err != nil {
+		return err
+	}
+	defer m.close()
+	file, err := newFileFromMeta(m)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Nodes are created first, and then their links are rewritten by new ids.
+	ids := map[int]int{}
+	err = g.eachNode(func(node Node) error {
+		if node.isLeaf() {
+			v, err := g.vector(node)
+			if err != nil {
+				return err
+			}
+			node.v = v
+		}
+		id, err := file.Create(node)
+		ids[node.id] = id
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	newId := func(id int) (int, error) {
+		if id == -1 {
+			return id, nil
+		}
+		if newId, ok := ids[id]; ok {
+			return newId, nil
+		}
+		return -1, fmt.Errorf("Node %d is not found.", id)
+	}
+	err = g.eachNode(func(node Node) error {
+		id := ids[node.id]
+		if node.isLeaf() {
+			for index, parent := range node.parents {
+				parent, err := newId(parent)
+				if err != nil {
+					return err
+				}
+				if err := file.UpdateParent(id, index, parent); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		for i, parent := range node.parents {
+			if node.parents[i], err = newId(parent); err != nil {
+				return err
+			}
+		}
+		for i, child := range node.children {
+			if node.children[i], err = newId(child); err != nil {
+				return err
+			}
+		}
+		node.id = id
+		return file.Update(node)
+	})
+	if err != nil {
+		return err
+	}
+
+	for index, root := range g.meta.roots() {
+		root, err := newId(root)
+		if err != nil {
+			return err
+		}
+		if err := m.updateRoot(index, root); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eachNode calls fn for live nodes until it returns error.
+func (g GannoyIndex) eachNode(fn func(Node) error) error {
+	var err error
+	iterator := make(chan Node)
+	go g.nodes.Iterate(iterator)
+	for node := range iterator {
+		if err != nil || node.free {
+			continue // drain, so that Iterate does not block
+		}
+		err = fn(node)
+	}
+	return err
+}
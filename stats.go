@@ -0,0 +1,178 @@
+package gannoy
+
+import (
+	"sync"
+)
+
+type Stats struct {
+	Tree         int           `json:"tree"`
+	Dim          int           `json:"dim"`
+	K            int           `json:"K"`
+	Encoding     string        `json:"encoding"`
+	Version      int           `json:"format_version"`
+	Endian       string        `json:"endian"`
+	Items        int           `json:"items"`
+	Nodes        int           `json:"nodes"`
+	FreeNodes    int           `json:"free_nodes"`
+	FileSize     int64         `json:"file_size"`
+	Trees        []TreeStats   `json:"trees"`
+	Cache        *CacheStats   `json:"cache,omitempty"`
+	ChangeSeq    uint64        `json:"change_seq,omitempty"`    // last sequence number of change log
+	Shards       int           `json:"shards,omitempty"`        // number of shards of ShardedIndex
+	Durability   string        `json:"durability"`              // policy of syncing files
+	SyncInterval string        `json:"sync_interval,omitempty"` // interval of "interval" durability
+	WALError     string        `json:"wal_error,omitempty"`     // last error of checkpoint of write-ahead log
+	FailedWrites []WriteStatus `json:"failed_writes,omitempty"` // writes left in write-ahead log which failed to be applied again
+}
+
+type TreeStats struct {
+	Root        int         `json:"root"`
+	Leaves      int         `json:"leaves"`
+	SplitNodes  int         `json:"split_nodes"`
+	BucketNodes int         `json:"bucket_nodes"`
+	MinDepth    int         `json:"min_depth"`
+	MaxDepth    int         `json:"max_depth"`
+	AvgDepth    float64     `json:"avg_depth"`
+	Depths      map[int]int `json:"depths"` // depth -> number of leaves
+	MinFill     float64     `json:"min_fill"`
+	MaxFill     float64     `json:"max_fill"`
+	AvgFill     float64     `json:"avg_fill"` // children / K of bucket nodes
+}
+
+// Stats walks every tree and reports its shape.
+// This reads all reachable nodes, so it is as expensive as a full scan.
+func (g GannoyIndex) Stats() (Stats, error) {
+	roots := g.meta.roots()
+	stats := Stats{
+		Tree:       g.tree,
+		Dim:        g.dim,
+		K:          g.K,
+		Encoding:   encodingName(g.meta.encoding),
+		Version:    g.meta.header.version,
+		Endian:     endianName(g.meta.header.endian),
+		Items:      g.nodes.maps.count(),
+		FreeNodes:  g.nodes.free.count(),
+		Trees:      make([]TreeStats, len(roots)),
+		Durability: durabilityName(g.durability.Policy),
+	}
+	if s, ok := g.nodes.backend().(sizer); ok {
+		stats.Nodes = s.nodeCount()
+		stats.FileSize = s.size()
+	}
+	if g.durability.Policy == DURABILITY_INTERVAL {
+		stats.SyncInterval = g.durability.Interval.String()
+	}
+	if g.changes != nil {
+		stats.ChangeSeq = g.changes.lastSeq()
+	}
+	if g.queue != nil {
+		g.queue.mu.Lock()
+		if g.queue.err != nil {
+			stats.WALError = g.queue.err.Error()
+		}
+		stats.FailedWrites = append([]WriteStatus{}, g.queue.failed...)
+		g.queue.mu.Unlock()
+	}
+	if cache, ok := g.nodes.Storage.(*CacheStorage); ok {
+		// before walking trees through the cache
+		cacheStats := cache.Stats()
+		stats.Cache = &cacheStats
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(roots))
+	errs := make([]error, len(roots))
+	statsChan := make(chan int, len(roots))
+	worker := func() {
+		for index := range statsChan {
+			stats.Trees[index], errs[index] = g.treeStats(index, roots[index])
+			wg.Done()
+		}
+	}
+	for i := 0; i < g.numWorker; i++ {
+		go worker()
+	}
+	for index, _ := range roots {
+		statsChan <- index
+	}
+	wg.Wait()
+	close(statsChan)
+
+	for _, err := range errs {
+		if err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+func (g GannoyIndex) treeStats(index, root int) (TreeStats, error) {
+	stats := TreeStats{Root: root, Depths: map[int]int{}}
+	if root == -1 {
+		return stats, nil
+	}
+
+	var fill float64
+	var depths int
+	addLeaf := func(depth int) {
+		stats.Leaves++
+		stats.Depths[depth]++
+		depths += depth
+		if stats.Leaves == 1 || depth < stats.MinDepth {
+			stats.MinDepth = depth
+		}
+		if depth > stats.MaxDepth {
+			stats.MaxDepth = depth
+		}
+	}
+
+	var walk func(id, depth int) error
+	walk = func(id, depth int) error {
+		node, err := g.nodes.getNode(id)
+		if err != nil {
+			return err
+		}
+		if node.isLeaf() {
+			addLeaf(depth)
+			return nil
+		}
+		if node.isBucket() {
+			ratio := float64(len(node.children)) / float64(g.K)
+			if stats.BucketNodes == 0 || ratio < stats.MinFill {
+				stats.MinFill = ratio
+			}
+			if ratio > stats.MaxFill {
+				stats.MaxFill = ratio
+			}
+			fill += ratio
+			stats.BucketNodes++
+			for range node.children {
+				addLeaf(depth + 1)
+			}
+			return nil
+		}
+		stats.SplitNodes++
+		for _, child := range node.children {
+			if err := walk(child, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := walk(root, 0); err != nil {
+		return stats, err
+	}
+	if stats.Leaves > 0 {
+		stats.AvgDepth = float64(depths) / float64(stats.Leaves)
+	}
+	if stats.BucketNodes > 0 {
+		stats.AvgFill = fill / float64(stats.BucketNodes)
+	}
+	return stats, nil
+}
+
+type sizer interface {
+	nodeCount() int
+	size() int64
+}
@@ -0,0 +1,80 @@
+package gannoy
+
+import (
+	"os"
+	"testing"
+)
+
+func TestGannoyIndexStats(t *testing.T) {
+	tree := 2
+	K := 4
+	name := "test_gannoy_index_stats"
+	CreateMeta(".", name, tree, 3, K)
+	defer os.Remove(name + ".meta")
+
+	treeFile := name + ".tree"
+	defer os.Remove(treeFile)
+	defer os.Remove(leavesPath(treeFile))
+	gannoy, _ := NewGannoyIndex(name+".meta", Angular{}, &TestLoopRandom{max: 1})
+
+	items := [][]float64{
+		{1.1, 1.2, 1.3},
+		{-1.1, -1.2, -1.3},
+		{1.1, 1.2, 1.3},
+		{-1.1, -1.2, -1.3},
+		{-1.1, -1.2, -1.3},
+	}
+	for i, item := range items {
+		gannoy.AddItem(i*10, item)
+	}
+
+	stats, err := gannoy.Stats()
+	if err != nil {
+		t.Errorf("GannoyIndex Stats should not return error.")
+	}
+	if stats.Items != len(items) {
+		t.Errorf("GannoyIndex Stats should contain items %d, but %d", len(items), stats.Items)
+	}
+	if stats.FileSize == 0 {
+		t.Errorf("GannoyIndex Stats should contain file size.")
+	}
+	if len(stats.Trees) != tree {
+		t.Errorf("GannoyIndex Stats should contain %d trees, but %d", tree, len(stats.Trees))
+	}
+	for i, tree := range stats.Trees {
+		if tree.Leaves != len(items) {
+			t.Errorf("Tree %d should contain leaves %d, but %d", i, len(items), tree.Leaves)
+		}
+		count := 0
+		for _, c := range tree.Depths {
+			count += c
+		}
+		if count != len(items) {
+			t.Errorf("Tree %d depth distribution should cover %d leaves, but %d", i, len(items), count)
+		}
+		if tree.BucketNodes == 0 || tree.AvgFill <= 0 || tree.MaxFill > 1.0 {
+			t.Errorf("Tree %d should report bucket fill ratio, but %v", i, tree)
+		}
+	}
+}
+
+func TestGannoyIndexStatsEmpty(t *testing.T) {
+	name := "test_gannoy_index_stats_empty"
+	CreateMeta(".", name, 2, 3, 4)
+	defer os.Remove(name + ".meta")
+
+	treeFile := name + ".tree"
+	defer os.Remove(treeFile)
+	defer os.Remove(leavesPath(treeFile))
+	gannoy, _ := NewGannoyIndex(name+".meta", Angular{}, RandRandom{})
+
+	stats, err := gannoy.Stats()
+	if err != nil {
+		t.Errorf("GannoyIndex Stats should not return error.")
+	}
+	for i, tree := range stats.Trees {
+		if tree.Root != -1 || tree.Leaves != 0 {
+			t.Errorf("Tree %d of empty database should be empty, but %v", i, tree)
+		}
+	}
+}
@@ -1,5 +1,9 @@
 package gannoy
 
+import (
+	"fmt"
+)
+
 type Storage interface {
 	Create(Node) (int, error)
 	Find(int) (Node, error)
@@ -8,3 +12,85 @@ type Storage interface {
 	Delete(Node) error
 	Iterate(chan Node)
 }
+
+// vectorFinder is implemented by storages which keep full-precision vectors
+// of quantized leaves.
+type vectorFinder interface {
+	findVector(int) ([]float64, bool, error)
+}
+
+// splitQuantizer is implemented by storages which keep split planes in lower precision.
+type splitQuantizer interface {
+	quantizeSplit([]float64) []float64
+}
+
+// leafIds is implemented by storages which give leaves ids apart from other nodes.
+type leafIds interface {
+	isLeafId(int) bool
+}
+
+func newStorage(c StorageConfig, storage int) (Storage, error) {
+	m := c.meta
+	if storage == BOLT {
+		return newBoltStorage(m, c.ReadOnly)
+	}
+	file, err := newFileFromMeta(m)
+	if err != nil {
+		return nil, err
+	}
+	switch storage {
+	case FILE:
+		return file, nil
+	case MMAP:
+		return newMmapFile(file)
+	case MEMORY:
+		return newMemoryStorageFrom(file), nil
+	default:
+		return nil, fmt.Errorf("Unknown storage: %d.", storage)
+	}
+}
+
+func newFileFromMeta(m meta) (*File, error) {
+	switch m.encoding {
+	case INT8:
+		leafCodec, err := newInt8Codec(m.dim, m.min, m.max)
+		if err != nil {
+			return nil, err
+		}
+		vectors, err := newVectors(m.vectorPath(), m.dim, m.readOnly)
+		if err != nil {
+			return nil, err
+		}
+		return newFileWithCodecs(m.treePath(), m.tree, m.dim, m.K, leafCodec, newUnitInt8Codec(m.dim), vectors, m.treeHeader(), m.readOnly)
+	case PQ:
+		vectors, err := newVectors(m.vectorPath(), m.dim, m.readOnly)
+		if err != nil {
+			return nil, err
+		}
+		return newFileWithCodecs(m.treePath(), m.tree, m.dim, m.K, pqCodec{codebook: m.codebook}, newUnitInt8Codec(m.dim), vectors, m.treeHeader(), m.readOnly)
+	case FLOAT64, FLOAT32:
+		codec, err := newCodec(m.encoding, m.dim, m.header.order())
+		if err != nil {
+			return nil, err
+		}
+		return newFileWithCodecs(m.treePath(), m.tree, m.dim, m.K, codec, codec, nil, m.treeHeader(), m.readOnly)
+	default:
+		return nil, fmt.Errorf("Unknown encoding: %d.", m.encoding)
+	}
+}
+
+// StorageFromName returns storage constant from name such as "mmap".
+func StorageFromName(name string) (int, error) {
+	switch name {
+	case "file":
+		return FILE, nil
+	case "mmap":
+		return MMAP, nil
+	case "memory":
+		return MEMORY, nil
+	case "bolt":
+		return BOLT, nil
+	default:
+		return -1, fmt.Errorf("Unknown storage: %s.", name)
+	}
+}
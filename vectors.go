@@ -0,0 +1,73 @@
+package gannoy
+
+import (
+	"encoding/binary"
+	"os"
+	"syscall"
+)
+
+// vectors keeps full-precision leaf vectors by slot of leaves file (or node id of
+// files without leaves file) in a side file, so that quantized leaves can be re-ranked.
+type vectors struct {
+	dim    int
+	file   *os.File
+	locker Locker
+}
+
+func newVectors(filename string, dim int, readOnly bool) (*vectors, error) {
+	if readOnly {
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		return &vectors{dim: dim, file: file, locker: noLocker{}}, nil
+	}
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &vectors{
+		dim:    dim,
+		file:   file,
+		locker: newLocker(),
+	}, nil
+}
+
+func (vs *vectors) size() int64 {
+	return int64(8 * vs.dim)
+}
+
+func (vs *vectors) offset(id int) int64 {
+	return int64(id) * vs.size()
+}
+
+func (vs *vectors) write(id int, v []float64) error {
+	b := make([]byte, vs.size())
+	float64Codec{dim: vs.dim, order: binary.BigEndian}.encode(b, v)
+
+	offset := vs.offset(id)
+	err := vs.locker.WriteLock(vs.file.Fd(), offset, vs.size())
+	if err != nil {
+		return err
+	}
+	defer vs.locker.UnLock(vs.file.Fd(), offset, vs.size())
+
+	_, err = syscall.Pwrite(int(vs.file.Fd()), b, offset)
+	return err
+}
+
+func (vs *vectors) read(id int) ([]float64, error) {
+	offset := vs.offset(id)
+	err := vs.locker.ReadLock(vs.file.Fd(), offset, vs.size())
+	if err != nil {
+		return nil, err
+	}
+	defer vs.locker.UnLock(vs.file.Fd(), offset, vs.size())
+
+	b := make([]byte, vs.size())
+	_, err = syscall.Pread(int(vs.file.Fd()), b, offset)
+	if err != nil {
+		return nil, err
+	}
+	return float64Codec{dim: vs.dim, order: binary.BigEndian}.decode(b), nil
+}
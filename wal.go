@@ -0,0 +1,268 @@
+package gannoy
+
+import (
+	"fmt"
+	"sync"
+)
+
+var walMagic = [4]byte{'G', 'N', 'Y', 'W'}
+
+// Status of asynchronous writes.
+const (
+	WriteQueued  = "queued"
+	WriteApplied = "applied"
+	WriteFailed  = "failed"
+)
+
+// Number of completed writes whose status is kept.
+const writeStatusLimit = 10000
+
+// Number of completed writes between checkpoints of write-ahead log under load.
+const walCheckpointWrites = 1000
+
+// WriteStatus is the state of a write queued by AddItemAsync, UpdateItemAsync or RemoveItemAsync.
+type WriteStatus struct {
+	ID     uint64 `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// writeQueue records asynchronous writes in write-ahead log before they are applied.
+// Writes of the same key are applied in order. Applied writes are removed from the log
+// by checkpoints, and writes left in it are applied again when the database is opened.
+type writeQueue struct {
+	mu        sync.Mutex
+	log       *changeLog
+	pending   int
+	queued    map[uint64]bool       // writes not completed yet
+	completed int                   // writes completed since the last checkpoint
+	inflight  map[int]chan struct{} // done of the last write of each key
+	writes    map[uint64]*queuedWrite
+	done      []uint64      // completed writes in order
+	failed    []WriteStatus // writes left in the log which failed to be applied again
+	err       error         // last error of checkpoint, which is kept until it succeeds
+
+	checkpointing sync.Mutex
+}
+
+type queuedWrite struct {
+	status WriteStatus
+	action int
+	key    int
+	w      []float64
+	replay bool
+	done   chan struct{}
+}
+
+// openWriteQueue opens write-ahead log, and returns writes left in it.
+func openWriteQueue(filename string, dim int, h header) (*writeQueue, []*queuedWrite, error) {
+	log, err := openChangeLog(filename, dim, h)
+	if err != nil {
+		return nil, nil, err
+	}
+	left := []*queuedWrite{}
+	err = log.read(0, func(c Change) error {
+		action, err := actionFromName(c.Action)
+		if err != nil {
+			return err
+		}
+		left = append(left, &queuedWrite{status: WriteStatus{ID: c.Seq}, action: action, key: c.Key, w: c.W})
+		return nil
+	})
+	if err != nil {
+		log.close()
+		return nil, nil, err
+	}
+	q := &writeQueue{
+		log:      log,
+		queued:   map[uint64]bool{},
+		inflight: map[int]chan struct{}{},
+		writes:   map[uint64]*queuedWrite{},
+	}
+	return q, left, nil
+}
+
+// AddItemAsync queues addition of the item, and returns id of the write once it is synced in write-ahead log.
+func (g *GannoyIndex) AddItemAsync(key int, w []float64) (uint64, error) {
+	return g.enqueue(ADD, key, w)
+}
+
+// UpdateItemAsync queues update of the item, and returns id of the write once it is in write-ahead log.
+func (g *GannoyIndex) UpdateItemAsync(key int, w []float64) (uint64, error) {
+	return g.enqueue(UPDATE, key, w)
+}
+
+// RemoveItemAsync queues removal of the item, and returns id of the write once it is in write-ahead log.
+func (g *GannoyIndex) RemoveItemAsync(key int) (uint64, error) {
+	return g.enqueue(DELETE, key, nil)
+}
+
+// GetWriteStatus returns status of the write.
+func (g *GannoyIndex) GetWriteStatus(id uint64) (WriteStatus, error) {
+	write, err := g.queuedWrite(id)
+	if err != nil {
+		return WriteStatus{}, err
+	}
+	g.queue.mu.Lock()
+	defer g.queue.mu.Unlock()
+	return write.status, nil
+}
+
+// WaitWrite waits until the write is applied or failed, and returns its status.
+func (g *GannoyIndex) WaitWrite(id uint64) (WriteStatus, error) {
+	write, err := g.queuedWrite(id)
+	if err != nil {
+		return WriteStatus{}, err
+	}
+	<-write.done
+	return g.GetWriteStatus(id)
+}
+
+func (g *GannoyIndex) queuedWrite(id uint64) (*queuedWrite, error) {
+	if g.queue == nil {
+		return nil, fmt.Errorf("Write-ahead log is disabled.")
+	}
+	g.queue.mu.Lock()
+	defer g.queue.mu.Unlock()
+	write, ok := g.queue.writes[id]
+	if !ok {
+		return nil, fmt.Errorf("Not found")
+	}
+	return write, nil
+}
+
+func (g *GannoyIndex) enqueue(action, key int, w []float64) (uint64, error) {
+	if g.readOnly {
+		return 0, ErrReadOnly
+	}
+	if g.queue == nil {
+		return 0, fmt.Errorf("Write-ahead log is disabled.")
+	}
+	q := g.queue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	id, err := q.log.append(action, key, w)
+	if err != nil {
+		return 0, err
+	}
+	// The write must survive a crash once its id is returned, regardless of durability.
+	if err := q.log.sync(); err != nil {
+		return 0, err
+	}
+	// Caller may reuse w after it is queued.
+	w = append([]float64{}, w...)
+	g.dispatch(&queuedWrite{action: action, key: key, w: w}, id, false)
+	return id, nil
+}
+
+// replayQueue applies writes left in write-ahead log. They may have been applied before the log was cleared,
+// so that addition of an existing key replaces it, and removal of a missing key is regarded as applied.
+func (g *GannoyIndex) replayQueue(left []*queuedWrite) {
+	g.queue.mu.Lock()
+	defer g.queue.mu.Unlock()
+
+	for _, write := range left {
+		g.dispatch(write, write.status.ID, true)
+	}
+}
+
+// dispatch applies the write after the previous write of the same key.
+// It must be called with lock of the queue.
+func (g *GannoyIndex) dispatch(write *queuedWrite, id uint64, replay bool) {
+	q := g.queue
+	write.status = WriteStatus{ID: id, Status: WriteQueued}
+	write.replay = replay
+	write.done = make(chan struct{})
+	prev := q.inflight[write.key]
+	q.inflight[write.key] = write.done
+	q.writes[id] = write
+	q.queued[id] = true
+	q.pending++
+
+	go func() {
+		if prev != nil {
+			<-prev
+		}
+		g.complete(write, g.applyQueued(write))
+	}()
+}
+
+func (g *GannoyIndex) applyQueued(write *queuedWrite) error {
+	if write.replay {
+		return g.replay(write.action, write.key, write.w)
+	}
+	switch write.action {
+	case ADD:
+		return g.AddItem(write.key, write.w)
+	case DELETE:
+		return g.RemoveItem(write.key)
+	default:
+		return g.UpdateItem(write.key, write.w)
+	}
+}
+
+// complete records the result of the write, and checkpoints the log when all writes are
+// completed or every walCheckpointWrites writes.
+func (g *GannoyIndex) complete(write *queuedWrite, err error) {
+	q := g.queue
+	q.mu.Lock()
+	if err != nil {
+		write.status.Status = WriteFailed
+		write.status.Error = err.Error()
+		if write.replay {
+			// Caller of the write is gone with crash.
+			q.failed = append(q.failed, write.status)
+		}
+	} else {
+		write.status.Status = WriteApplied
+	}
+	if q.inflight[write.key] == write.done {
+		delete(q.inflight, write.key)
+	}
+	delete(q.queued, write.status.ID)
+
+	q.done = append(q.done, write.status.ID)
+	if len(q.done) > writeStatusLimit {
+		delete(q.writes, q.done[0])
+		q.done = q.done[1:]
+	}
+
+	q.pending--
+	q.completed++
+	checkpoint := q.pending == 0 || q.completed >= walCheckpointWrites
+	if checkpoint {
+		q.completed = 0
+	}
+	q.mu.Unlock()
+
+	if checkpoint {
+		g.checkpoint()
+	}
+	close(write.done)
+}
+
+// checkpoint syncs applied writes to disk, and removes writes up to the last one
+// completed in order from the log. Writes queued after it are left in the log.
+func (g *GannoyIndex) checkpoint() {
+	q := g.queue
+	q.checkpointing.Lock()
+	defer q.checkpointing.Unlock()
+
+	q.mu.Lock()
+	seq := q.log.lastSeq()
+	for id := range q.queued {
+		if id <= seq {
+			seq = id - 1
+		}
+	}
+	q.mu.Unlock()
+
+	err := g.sync()
+	if err == nil {
+		err = q.log.truncate(seq)
+	}
+	q.mu.Lock()
+	q.err = err
+	q.mu.Unlock()
+}
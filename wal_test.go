@@ -0,0 +1,172 @@
+package gannoy
+
+import (
+	"os"
+	"testing"
+)
+
+func TestGannoyIndexAsyncWrite(t *testing.T) {
+	name := "test_gannoy_index_async_write"
+	CreateMeta(".", name, 2, 3, 4)
+	defer os.Remove(name + ".meta")
+	defer os.Remove(name + ".tree")
+	defer os.Remove(name + ".leaves")
+	defer os.Remove(name + ".wal")
+
+	index, _ := NewGannoyIndexWithOptions(name+".meta", Options{})
+	if _, err := index.AddItemAsync(0, []float64{1.1, 1.2, 1.3}); err == nil {
+		t.Errorf("AddItemAsync without write-ahead log should return error.")
+	}
+
+	index, _ = NewGannoyIndexWithOptions(name+".meta", Options{WAL: true})
+	ids := []uint64{}
+	for key := 0; key < 10; key++ {
+		id, err := index.AddItemAsync(key, []float64{1.1, 1.2, float64(key)})
+		if err != nil {
+			t.Errorf("AddItemAsync should not return error, but %v", err)
+		}
+		ids = append(ids, id)
+	}
+	update, _ := index.UpdateItemAsync(3, []float64{-1.1, -1.2, -1.3})
+	remove, _ := index.RemoveItemAsync(3)
+	failed, _ := index.AddItemAsync(20, []float64{1.1, 1.2})
+
+	for _, id := range append(ids, update, remove) {
+		status, err := index.WaitWrite(id)
+		if err != nil || status.ID != id || status.Status != WriteApplied {
+			t.Errorf("WaitWrite should return applied status of %d, but %v, %v", id, status, err)
+		}
+	}
+	status, _ := index.WaitWrite(failed)
+	if status.Status != WriteFailed || status.Error == "" {
+		t.Errorf("WaitWrite should return failed status, but %v", status)
+	}
+	if index.nodes.maps.isExist(3) || !index.nodes.maps.isExist(9) {
+		t.Errorf("Writes of the same key should be applied in order, but %v", index.nodes.maps.keys())
+	}
+	if _, err := index.GetWriteStatus(failed + 1); err == nil {
+		t.Errorf("GetWriteStatus of unknown write should return error.")
+	}
+
+	// Log is cleared after all writes are applied.
+	info, _ := os.Stat(name + ".wal")
+	if info.Size() != headerSize {
+		t.Errorf("Write-ahead log should be cleared, but %d bytes", info.Size())
+	}
+}
+
+func TestGannoyIndexReplayQueue(t *testing.T) {
+	name := "test_gannoy_index_replay_queue"
+	CreateMeta(".", name, 2, 3, 4)
+	defer os.Remove(name + ".meta")
+	defer os.Remove(name + ".tree")
+	defer os.Remove(name + ".leaves")
+	defer os.Remove(name + ".wal")
+
+	index, _ := NewGannoyIndexWithOptions(name+".meta", Options{})
+	index.AddItem(0, []float64{1.1, 1.2, 1.3})
+
+	// Writes left by crash. The first one has been applied already.
+	h := newHeader(walMagic, BIG_ENDIAN, index.meta.header.metric)
+	log, _ := openChangeLog(name+".wal", 3, h)
+	log.append(ADD, 0, []float64{1.1, 1.2, 1.3})
+	log.append(ADD, 1, []float64{-1.1, -1.2, -1.3})
+	log.append(DELETE, 2, nil)
+	log.file.Close()
+
+	index, _ = NewGannoyIndexWithOptions(name+".meta", Options{WAL: true})
+	for id := uint64(1); id <= 3; id++ {
+		status, _ := index.WaitWrite(id)
+		if status.Status != WriteApplied {
+			t.Errorf("Write %d left in log should be applied, but %v", id, status)
+		}
+	}
+	if !index.nodes.maps.isExist(1) {
+		t.Errorf("Write left in log should be applied, but %v", index.nodes.maps.keys())
+	}
+
+	// Ids are continued.
+	id, _ := index.AddItemAsync(5, []float64{1.1, 1.2, 1.3})
+	if id != 4 {
+		t.Errorf("AddItemAsync should continue ids of log, but %d", id)
+	}
+	index.WaitWrite(id)
+}
+
+func TestGannoyIndexCheckpoint(t *testing.T) {
+	name := "test_gannoy_index_checkpoint"
+	CreateMeta(".", name, 2, 3, 4)
+	defer os.Remove(name + ".meta")
+	defer os.Remove(name + ".tree")
+	defer os.Remove(name + ".leaves")
+	defer os.Remove(name + ".wal")
+
+	// Writes left by crash. The second one fails to be applied again.
+	index, _ := NewGannoyIndexWithOptions(name+".meta", Options{})
+	h := newHeader(walMagic, BIG_ENDIAN, index.meta.header.metric)
+	log, _ := openChangeLog(name+".wal", 3, h)
+	log.append(ADD, 0, []float64{1.1, 1.2, 1.3})
+	log.append(ADD, 1, nil)
+	log.file.Close()
+
+	index, _ = NewGannoyIndexWithOptions(name+".meta", Options{WAL: true})
+	index.WaitWrite(1)
+	index.WaitWrite(2)
+	stats, _ := index.Stats()
+	if len(stats.FailedWrites) != 1 || stats.FailedWrites[0].ID != 2 || stats.WALError != "" {
+		t.Errorf("Stats should report writes failed to be applied again, but %v", stats.FailedWrites)
+	}
+
+	// Writes not applied yet are left in log.
+	resume := index.pauseWrites()
+	id, _ := index.AddItemAsync(10, []float64{1.1, 1.2, 1.3})
+	index.checkpoint()
+	left := []uint64{}
+	index.queue.log.read(0, func(c Change) error {
+		left = append(left, c.Seq)
+		return nil
+	})
+	if len(left) != 1 || left[0] != id {
+		t.Errorf("Checkpoint should leave writes not applied in log, but %v", left)
+	}
+	resume()
+	index.WaitWrite(id)
+	info, _ := os.Stat(name + ".wal")
+	if info.Size() != headerSize {
+		t.Errorf("Write-ahead log should be cleared, but %d bytes", info.Size())
+	}
+}
+
+func TestChangeLogTruncate(t *testing.T) {
+	name := "test_change_log_truncate.wal"
+	defer os.Remove(name)
+
+	h := newHeader(walMagic, BIG_ENDIAN, ANGULAR)
+	log, _ := openChangeLog(name, 3, h)
+	for i := 0; i < 3000; i++ {
+		log.append(ADD, i, []float64{1.1, 1.2, float64(i)})
+	}
+	size := log.end
+	if err := log.truncate(1500); err != nil {
+		t.Errorf("Change log truncate should not return error, but %v", err)
+	}
+	if seq, _ := log.append(ADD, 3000, []float64{1.1, 1.2, 1.3}); seq != 3001 {
+		t.Errorf("Change log should continue sequence %d after truncate, but %d", 3001, seq)
+	}
+	log.close()
+
+	// Records up to the sequence number are removed from file.
+	log, _ = openChangeLog(name, 3, h)
+	defer log.close()
+	seqs := []uint64{}
+	log.read(2000, func(c Change) error {
+		seqs = append(seqs, c.Seq)
+		return nil
+	})
+	if len(seqs) != 1001 || seqs[0] != 2001 || seqs[1000] != 3001 {
+		t.Errorf("Change log should keep records after truncated ones, but %d records", len(seqs))
+	}
+	if log.end >= size {
+		t.Errorf("Change log truncate should shrink file, but %d", log.end)
+	}
+}
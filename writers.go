@@ -0,0 +1,74 @@
+package gannoy
+
+import (
+	"sync"
+)
+
+// Number of locks shared by keys. Writes of keys in the same stripe are serialized.
+const keyLockStripes = 256
+
+// writeLocks coordinates builders which apply writes concurrently.
+//
+// A write locks its key, so that writes of the same key are applied and logged in order.
+// An insert descends each tree by lock coupling, holding the parent (or root of the tree)
+// while locking its child, so that inserts into different subtrees proceed concurrently.
+// A removal locks the whole tree because it walks up from the leaf.
+type writeLocks struct {
+	write sync.RWMutex // writes share, backup excludes
+	keys  [keyLockStripes]sync.Mutex
+	trees []*treeLocks
+}
+
+type treeLocks struct {
+	sync.RWMutex            // inserts share, removals exclude
+	root         sync.Mutex // guards root of the tree
+	nodes        nodeLocks
+}
+
+func newWriteLocks(tree int) *writeLocks {
+	l := &writeLocks{trees: make([]*treeLocks, tree)}
+	for i := range l.trees {
+		l.trees[i] = &treeLocks{nodes: nodeLocks{locks: map[int]*nodeLock{}}}
+	}
+	return l
+}
+
+func (l *writeLocks) key(key int) *sync.Mutex {
+	return &l.keys[ShardOf(key, keyLockStripes)]
+}
+
+// nodeLocks locks nodes of a tree by id.
+type nodeLocks struct {
+	mu    sync.Mutex
+	locks map[int]*nodeLock
+}
+
+type nodeLock struct {
+	sync.Mutex
+	refs int
+}
+
+func (l *nodeLocks) lock(id int) {
+	l.mu.Lock()
+	lock, ok := l.locks[id]
+	if !ok {
+		lock = &nodeLock{}
+		l.locks[id] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.Lock()
+}
+
+func (l *nodeLocks) unlock(id int) {
+	l.mu.Lock()
+	lock := l.locks[id]
+	lock.refs--
+	if lock.refs == 0 {
+		delete(l.locks, id)
+	}
+	l.mu.Unlock()
+
+	lock.Unlock()
+}
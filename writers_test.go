@@ -0,0 +1,156 @@
+package gannoy
+
+import (
+	"math/rand"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func TestGannoyIndexConcurrentWriters(t *testing.T) {
+	tree := 3
+	dim := 4
+	K := 5
+	name := "test_gannoy_index_concurrent_writers"
+	CreateMeta(".", name, tree, dim, K)
+	defer os.Remove(name + ".meta")
+	defer os.Remove(name + ".tree")
+	defer os.Remove(name + ".leaves")
+
+	index, _ := NewGannoyIndexWithOptions(name+".meta", Options{Writers: 8})
+
+	items := 200
+	vectors := make([][]float64, items)
+	for i := range vectors {
+		vectors[i] = make([]float64, dim)
+		for j := range vectors[i] {
+			vectors[i][j] = rand.Float64()*2 - 1
+		}
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for key := w; key < items; key += 8 {
+				if err := index.AddItem(key, vectors[key]); err != nil {
+					t.Errorf("AddItem should not return error, but %v", err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// Remove and update items concurrently with inserts of new items.
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for key := w; key < items; key += 8 {
+				var err error
+				switch key % 3 {
+				case 0:
+					err = index.RemoveItem(key)
+				case 1:
+					err = index.UpdateItem(key, vectors[items-key-1])
+				default:
+					err = index.AddItem(items+key, vectors[key])
+				}
+				if err != nil {
+					t.Errorf("Write of key %d should not return error, but %v", key, err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	keys := map[int]bool{}
+	for key := 0; key < items; key++ {
+		switch key % 3 {
+		case 0:
+		case 1:
+			keys[key] = true
+		default:
+			keys[key] = true
+			keys[items+key] = true
+		}
+	}
+
+	for i, root := range index.meta.roots() {
+		found := map[int]bool{}
+		checkTree(t, index, i, root, -1, found)
+		if len(found) != len(keys) {
+			t.Errorf("Tree %d should contain %d items, but %d", i, len(keys), len(found))
+		}
+		for key := range keys {
+			if !found[key] {
+				t.Errorf("Tree %d should contain key %d", i, key)
+			}
+		}
+	}
+}
+
+func TestGannoyIndexTreeWorkers(t *testing.T) {
+	// Trees of an item are built by workers at once, which update parents of the same leaf.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(16))
+
+	tree := 16
+	dim := 8
+	K := 10
+	name := "test_gannoy_index_tree_workers"
+	CreateMeta(".", name, tree, dim, K)
+	defer os.Remove(name + ".meta")
+	defer os.Remove(name + ".tree")
+	defer os.Remove(name + ".leaves")
+
+	index, _ := NewGannoyIndex(name+".meta", Angular{}, RandRandom{})
+	items := 2000
+	for key := 0; key < items; key++ {
+		w := make([]float64, dim)
+		for j := range w {
+			w[j] = rand.Float64()*2 - 1
+		}
+		if err := index.AddItem(key, w); err != nil {
+			t.Fatalf("AddItem should not return error, but %v", err)
+		}
+	}
+
+	for i, root := range index.meta.roots() {
+		found := map[int]bool{}
+		checkTree(t, index, i, root, -1, found)
+		if len(found) != items {
+			t.Errorf("Tree %d should contain %d items, but %d", i, items, len(found))
+		}
+	}
+}
+
+// checkTree collects keys under id, and checks parents of nodes and children of buckets.
+func checkTree(t *testing.T, index GannoyIndex, tree, id, parent int, keys map[int]bool) {
+	node, err := index.nodes.getNode(id)
+	if err != nil {
+		t.Fatalf("Node %d should be found, but %v", id, err)
+	}
+	if node.parents[tree] != parent {
+		t.Errorf("Node %d of tree %d should have parent %d, but %d", id, tree, parent, node.parents[tree])
+	}
+	if node.isLeaf() {
+		if keys[node.key] {
+			t.Errorf("Key %d is duplicated in tree %d", node.key, tree)
+		}
+		keys[node.key] = true
+		return
+	}
+	if node.isBucket() && node.nDescendants != len(node.children) {
+		t.Errorf("Bucket %d of tree %d should have %d descendants, but %d", id, tree, len(node.children), node.nDescendants)
+	}
+	for _, child := range node.children {
+		if node.isBucket() {
+			if c, _ := index.nodes.getNode(child); !c.isLeaf() {
+				t.Errorf("Bucket %d of tree %d should contain only leaves, but %d", id, tree, child)
+			}
+		}
+		checkTree(t, index, tree, child, id, keys)
+	}
+}